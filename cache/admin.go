@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Admin routes live under the pool's base path. Group names starting with an
+// underscore are reserved for them.
+const (
+	ringPath   = "_ring"   // cluster-wide view, served to operators
+	localPath  = "_local"  // this node's own holdings, queried by _ring
+	healthPath = "_health" // liveness, probed by peers' health checks
+)
+
+// errRingFormat is returned when a peer refuses this node's ring format.
+var errRingFormat = errors.New("ring format mismatch")
+
+// NodeStatus describes one pool member.
+type NodeStatus struct {
+	Addr    string  `json:"addr"`
+	Vnodes  int     `json:"vnodes"`  // 0 for placements without virtual nodes
+	Share   float64 `json:"share"`   // expected fraction of the keyspace
+	Keys    int     `json:"keys"`    // entries actually held, all groups
+	Bytes   int64   `json:"bytes"`   // bytes actually held, all groups
+	Breaker string  `json:"breaker"` // circuit state of calls to the node
+	Healthy bool    `json:"healthy"` // passes this node's health checks
+	Error   string  `json:"error,omitempty"`
+}
+
+// ReplicaStatus tells whether one replica of a key holds it.
+type ReplicaStatus struct {
+	Addr    string `json:"addr"`
+	Present bool   `json:"present"`
+	Error   string `json:"error,omitempty"`
+}
+
+// KeyStatus shows where a key lives.
+type KeyStatus struct {
+	Key      string          `json:"key"`
+	Group    string          `json:"group,omitempty"`
+	Owner    string          `json:"owner"`
+	Replicas []ReplicaStatus `json:"replicas"`
+}
+
+// GroupReplication reports whether a group gets the copies it asks for.
+type GroupReplication struct {
+	Group           string `json:"group"`
+	Configured      int    `json:"configured"`
+	Effective       int    `json:"effective"`
+	UnderReplicated bool   `json:"under_replicated"`
+}
+
+// RingStatus is a snapshot of how the pool maps keys.
+type RingStatus struct {
+	Self    string             `json:"self"`
+	Version uint64             `json:"version"` // membership version
+	Format  string             `json:"format"`  // see HTTPPool.RingFormat
+	Nodes   []NodeStatus       `json:"nodes"`
+	Groups  []GroupReplication `json:"groups,omitempty"`
+	Key     *KeyStatus         `json:"key,omitempty"`
+}
+
+// localStatus is what a node reports about itself on the _local route.
+type localStatus struct {
+	Keys    int   `json:"keys"`
+	Bytes   int64 `json:"bytes"`
+	Present bool  `json:"present"`
+}
+
+// RingStatus collects the ring layout and asks every member what it holds.
+// When key is set, it also reports the key's owner and replicas and whether
+// each replica holds it; group narrows that check to one group.
+func (p *HTTPPool) RingStatus(group, key string) RingStatus {
+	rf := defaultReplicationFactor
+	var replication []GroupReplication
+	for _, g := range registeredGroups(p) {
+		configured, effective := g.ReplicationStatus()
+		replication = append(replication, GroupReplication{
+			Group:           g.name,
+			Configured:      configured,
+			Effective:       effective,
+			UnderReplicated: effective < configured,
+		})
+		if g.name == group {
+			rf = configured
+		}
+	}
+	sort.Slice(replication, func(i, j int) bool {
+		return replication[i].Group < replication[j].Group
+	})
+
+	state := p.state.Load()
+	shares := state.shares()
+	ring, isRing := state.ring()
+	status := RingStatus{Self: p.self, Version: state.version, Format: p.format, Groups: replication}
+	for addr, getter := range state.httpGetters {
+		node := NodeStatus{Addr: addr, Share: shares[addr], Breaker: getter.BreakerState().String(), Healthy: getter.Healthy()}
+		if isRing {
+			node.Vnodes = ring.VirtualNodes(addr)
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	var replicas []string
+	if key != "" {
+		replicas = state.peers.Replicas(key, rf)
+		status.Key = &KeyStatus{Key: key, Group: group, Owner: state.peers.Owner(key)}
+	}
+	sort.Slice(status.Nodes, func(i, j int) bool {
+		return status.Nodes[i].Addr < status.Nodes[j].Addr
+	})
+
+	// Query every member once; replicas of the key are among them.
+	results := make(map[string]localStatus, len(state.httpGetters))
+	errs := make(map[string]error)
+	var (
+		wg  sync.WaitGroup
+		rmu sync.Mutex
+	)
+	for addr, getter := range state.httpGetters {
+		wg.Add(1)
+		go func(addr string, getter *HTTPGetter) {
+			defer wg.Done()
+			var (
+				st  localStatus
+				err error
+			)
+			if addr == p.self {
+				st = localStats(group, key)
+			} else {
+				st, err = getter.localStatus(group, key)
+			}
+			rmu.Lock()
+			defer rmu.Unlock()
+			if err != nil {
+				errs[addr] = err
+				return
+			}
+			results[addr] = st
+		}(addr, getter)
+	}
+	wg.Wait()
+
+	for i := range status.Nodes {
+		node := &status.Nodes[i]
+		if err := errs[node.Addr]; err != nil {
+			node.Error = err.Error()
+			continue
+		}
+		node.Keys, node.Bytes = results[node.Addr].Keys, results[node.Addr].Bytes
+	}
+	for _, addr := range replicas {
+		rs := ReplicaStatus{Addr: addr, Present: results[addr].Present}
+		if err := errs[addr]; err != nil {
+			rs.Error = err.Error()
+		}
+		status.Key.Replicas = append(status.Key.Replicas, rs)
+	}
+	return status
+}
+
+// serveAdmin handles the admin routes and reports whether subpath was one.
+func (p *HTTPPool) serveAdmin(w http.ResponseWriter, r *http.Request, subpath string) bool {
+	switch subpath {
+	case ringPath, localPath, healthPath:
+	default:
+		return false
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return true
+	}
+	var body interface{}
+	query := r.URL.Query()
+	switch subpath {
+	case ringPath:
+		body = p.RingStatus(query.Get("group"), query.Get("key"))
+	case localPath:
+		body = localStats(query.Get("group"), query.Get("key"))
+	case healthPath:
+		body = map[string]string{"status": "ok"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		p.Log("encoding %s: %v", subpath, err)
+	}
+	return true
+}
+
+// localStats sums this node's holdings across groups and checks whether key
+// is held, in group if given or in any group otherwise.
+func localStats(group, key string) localStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+	var st localStatus
+	for name, g := range groups {
+		keys, bytes := g.cache.Stats()
+		st.Keys += keys
+		st.Bytes += bytes
+		if key != "" && (group == "" || group == name) && g.cache.Contains(key) {
+			st.Present = true
+		}
+	}
+	return st
+}
+
+func (h *HTTPGetter) localStatus(group, key string) (localStatus, error) {
+	var st localStatus
+	q := url.Values{}
+	if group != "" {
+		q.Set("group", group)
+	}
+	if key != "" {
+		q.Set("key", key)
+	}
+	res, err := h.do(context.Background(), http.MethodGet, h.baseURL+localPath+"?"+q.Encode(), nil)
+	if err != nil {
+		return st, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return st, fmt.Errorf("%w: %s", errRingFormat, strings.TrimSpace(string(msg)))
+	}
+	if res.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("server returned: %v", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&st)
+	return st, err
+}
@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Headers of signed requests.
+const (
+	keyIDHeader     = "X-Dcache-Key-Id"
+	timestampHeader = "X-Dcache-Timestamp"
+	nonceHeader     = "X-Dcache-Nonce"
+	signatureHeader = "X-Dcache-Signature"
+)
+
+const (
+	defaultReplayWindow = 30 * time.Second
+	// maxSignedBody bounds the request body read to check a signature.
+	maxSignedBody = 64 << 20
+)
+
+var (
+	ErrUnsigned     = errors.New("auth: request is not signed")
+	ErrBadSignature = errors.New("auth: bad signature")
+	ErrStale        = errors.New("auth: request timestamp outside the replay window")
+	ErrReplayed     = errors.New("auth: request was already seen")
+)
+
+// SigningKey is a shared secret known to every peer under the same ID.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs requests with HMAC-SHA256 over the method, path, query,
+// timestamp, a nonce and the body hash, and verifies them on receipt.
+// Requests are signed with the first key and accepted under any key, so a
+// new key can be rolled out to every node before it is made first.
+type Signer struct {
+	keys   []SigningKey
+	byID   map[string][]byte
+	window time.Duration
+
+	mu       sync.Mutex
+	seen     map[string]time.Time // signatures accepted within the window
+	prunedAt time.Time
+}
+
+// NewSigner returns a signer for keys. Requests whose timestamp is more than
+// window away from the local clock are rejected (default 30s), as are
+// repeats of a request already accepted.
+func NewSigner(window time.Duration, keys ...SigningKey) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("auth: at least one signing key is required")
+	}
+	if window <= 0 {
+		window = defaultReplayWindow
+	}
+	s := &Signer{
+		keys:   keys,
+		byID:   make(map[string][]byte, len(keys)),
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+	for _, k := range keys {
+		if k.ID == "" || len(k.Secret) == 0 {
+			return nil, errors.New("auth: signing keys need an ID and a secret")
+		}
+		if _, dup := s.byID[k.ID]; dup {
+			return nil, fmt.Errorf("auth: duplicate key ID %q", k.ID)
+		}
+		s.byID[k.ID] = k.Secret
+	}
+	return s, nil
+}
+
+// WithSigner signs the pool's peer requests with s and makes ServeHTTP
+// reject requests not signed by a key s knows.
+func WithSigner(s *Signer) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.signer = s
+	}
+}
+
+// WithGRPCSigner signs the pool's peer calls with s and makes its server
+// reject calls not signed by a key s knows. Without it the gRPC transport is
+// unauthenticated.
+func WithGRPCSigner(s *Signer) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.signer = s
+		p.dialOpts = append(p.dialOpts, grpc.WithChainUnaryInterceptor(s.unaryClientInterceptor))
+	}
+}
+
+// unaryClientInterceptor signs a call over its method and marshaled request,
+// carrying the signature headers as metadata.
+func (s *Signer) unaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	body, err := marshalSigned(req)
+	if err != nil {
+		return err
+	}
+	var kv []string
+	err = s.sign(func(name, value string) { kv = append(kv, name, value) }, grpcSignMethod, method, "", body)
+	if err != nil {
+		return err
+	}
+	return invoker(metadata.AppendToOutgoingContext(ctx, kv...), method, req, reply, cc, opts...)
+}
+
+// unaryServerInterceptor rejects calls whose signature does not match.
+func (s *Signer) unaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(name string) string {
+		if v := md.Get(name); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if !signed(get) {
+		return nil, status.Error(codes.Unauthenticated, ErrUnsigned.Error())
+	}
+	body, err := marshalSigned(req)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.verify(get, grpcSignMethod, info.FullMethod, "", body); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(ctx, req)
+}
+
+// grpcSignMethod stands in for the HTTP method in gRPC call signatures.
+const grpcSignMethod = "GRPC"
+
+// marshalSigned encodes a call's request the same way on both ends.
+func marshalSigned(req interface{}) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("auth: cannot sign %T", req)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// Sign adds signature headers for body to req.
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	return s.sign(req.Header.Set, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, body)
+}
+
+// sign computes a signature for a request and passes each signature header
+// to set.
+func (s *Signer) sign(set func(name, value string), method, path, query string, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("auth: generating nonce: %w", err)
+	}
+	key := s.keys[0]
+	ts := strconv.FormatInt(nowFunc().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+	set(keyIDHeader, key.ID)
+	set(timestampHeader, ts)
+	set(nonceHeader, nonceHex)
+	set(signatureHeader, hex.EncodeToString(s.mac(key.Secret, method, path, query, ts, nonceHex, body)))
+	return nil
+}
+
+// Verify checks req's signature over body.
+func (s *Signer) Verify(req *http.Request, body []byte) error {
+	return s.verify(req.Header.Get, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, body)
+}
+
+// signed reports whether get returns every signature header, so unsigned
+// requests can be refused before their body is read.
+func signed(get func(name string) string) bool {
+	for _, name := range []string{keyIDHeader, timestampHeader, nonceHeader, signatureHeader} {
+		if get(name) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// verify checks the signature headers returned by get against a request.
+func (s *Signer) verify(get func(name string) string, method, path, query string, body []byte) error {
+	id, ts, nonce := get(keyIDHeader), get(timestampHeader), get(nonceHeader)
+	sig, err := hex.DecodeString(get(signatureHeader))
+	if id == "" || ts == "" || nonce == "" || err != nil || len(sig) == 0 {
+		return ErrUnsigned
+	}
+	secret, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("%w: unknown key %q", ErrBadSignature, id)
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrUnsigned
+	}
+	now := nowFunc()
+	if at := time.Unix(unix, 0); at.Before(now.Add(-s.window)) || at.After(now.Add(s.window)) {
+		return ErrStale
+	}
+	if !hmac.Equal(sig, s.mac(secret, method, path, query, ts, nonce, body)) {
+		return ErrBadSignature
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(sig)
+	if _, dup := s.seen[key]; dup {
+		return ErrReplayed
+	}
+	if now.Sub(s.prunedAt) > s.window {
+		s.prune(now)
+	}
+	// Timestamps up to a window ahead are accepted, so remember the
+	// signature until that timestamp has also left the window.
+	s.seen[key] = time.Unix(unix, 0).Add(s.window)
+	return nil
+}
+
+// prune forgets signatures whose timestamps have left the window. s.mu
+// must be held.
+func (s *Signer) prune(now time.Time) {
+	for sig, expire := range s.seen {
+		if now.After(expire) {
+			delete(s.seen, sig)
+		}
+	}
+	s.prunedAt = now
+}
+
+func (s *Signer) mac(secret []byte, method, path, query, ts, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	m := hmac.New(sha256.New, secret)
+	fmt.Fprintf(m, "%s\n%s\n%s\n%s\n%s\n%x", method, path, query, ts, nonce, bodyHash)
+	return m.Sum(nil)
+}
+
+// Handler rejects requests to next that s did not sign, e.g. to protect an
+// API server shared with trusted clients.
+func (s *Signer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.verifyRequest(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyRequest reads r's body to verify it and leaves a copy in its place.
+// Requests without signature headers are refused before the body is read,
+// and reading stops with an error past maxSignedBody bytes.
+func (s *Signer) verifyRequest(w http.ResponseWriter, r *http.Request) error {
+	if !signed(r.Header.Get) {
+		return ErrUnsigned
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody)); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return s.Verify(r, body)
+}
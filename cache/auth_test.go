@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"bytes"
+	pb "distributed-cache/cache/pb"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	old := SigningKey{ID: "k1", Secret: []byte("old-secret")}
+	next := SigningKey{ID: "k2", Secret: []byte("new-secret")}
+	signer, err := NewSigner(time.Minute, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A node that already knows the next key, but still signs with the old.
+	rotating, err := NewSigner(time.Minute, old, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A node that has switched to the next key.
+	rotated, err := NewSigner(time.Minute, next, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte("value")
+	newReq := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/dcache/g/k?x=1", bytes.NewReader(body))
+	}
+
+	req := newReq()
+	signer.Sign(req, body)
+	if err := rotating.Verify(req, body); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	if err := rotating.Verify(req, body); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected ErrReplayed, got %v", err)
+	}
+
+	req = newReq()
+	rotated.Sign(req, body)
+	if err := rotating.Verify(req, body); err != nil {
+		t.Fatalf("request signed with the next key rejected: %v", err)
+	}
+	if err := signer.Verify(req, body); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("unknown key should be rejected, got %v", err)
+	}
+
+	req = newReq()
+	signer.Sign(req, body)
+	if err := signer.Verify(req, []byte("other")); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered body should be rejected, got %v", err)
+	}
+	req.Method = http.MethodDelete
+	if err := signer.Verify(req, body); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("changed method should be rejected, got %v", err)
+	}
+
+	req = newReq()
+	signer.Sign(req, body)
+	now = now.Add(2 * time.Minute)
+	if err := signer.Verify(req, body); !errors.Is(err, ErrStale) {
+		t.Fatalf("expected ErrStale, got %v", err)
+	}
+	if err := signer.Verify(newReq(), body); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected ErrUnsigned, got %v", err)
+	}
+}
+
+func TestSignedPeerRequests(t *testing.T) {
+	signer, err := NewSigner(0, SigningKey{ID: "k1", Secret: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := startPools(t, 2, WithSigner(signer))
+	group := NewGroup("signed", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	peer := pools[0].state.Load().httpGetters[pools[1].self]
+
+	if err := peer.Set(&pb.SetRequest{Group: "signed", Key: "k", Value: []byte("v")}, &pb.EmptyResponse{}); err != nil {
+		t.Fatalf("signed write rejected: %v", err)
+	}
+	if v, ok := group.cache.Get("k"); !ok || v.String() != "v" {
+		t.Fatalf("signed write not applied")
+	}
+
+	res, err := http.Post(pools[1].self+defaultPath+"signed/k", "application/octet-stream", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned write should be rejected, got %s", res.Status)
+	}
+}
+
+// readTracker records whether its body was read.
+type readTracker struct{ read bool }
+
+func (r *readTracker) Read(p []byte) (int, error) {
+	r.read = true
+	return 0, io.EOF
+}
+
+func TestUnsignedBodyNotRead(t *testing.T) {
+	signer, err := NewSigner(0, SigningKey{ID: "k1", Secret: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &readTracker{}
+	req := httptest.NewRequest(http.MethodPost, "/dcache/g/k", body)
+	if err := signer.verifyRequest(httptest.NewRecorder(), req); !errors.Is(err, ErrUnsigned) || body.read {
+		t.Fatalf("unsigned request should be refused unread, got %v, read = %v", err, body.read)
+	}
+}
+
+func TestSignedGRPCCalls(t *testing.T) {
+	signer, err := NewSigner(0, SigningKey{ID: "k1", Secret: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewGroup("grpc-signed", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pools := startGRPCPools(t, 2, WithGRPCSigner(signer))
+	req := &pb.GetRequest{Group: "grpc-signed", Key: "k"}
+
+	var res pb.Response
+	if err := pools[0].state.Load().clients[pools[1].self].Get(req, &res); err != nil || string(res.Value) != "k" {
+		t.Fatalf("signed call failed: %q, %v", res.Value, err)
+	}
+
+	// The same dialer without the signing interceptor is refused.
+	opts := pools[0].dialOpts
+	unsigned := NewGRPCPool("unsigned", WithDialOptions(opts[:len(opts)-1]...))
+	defer unsigned.Close()
+	unsigned.Set(pools[1].self)
+	if err := unsigned.state.Load().clients[pools[1].self].Get(req, &res); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
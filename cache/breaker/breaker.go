@@ -0,0 +1,235 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while the circuit is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	Closed   State = iota // calls flow normally
+	Open                  // calls are rejected until the cooldown passes
+	HalfOpen              // a single probe call is let through
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config sets when a Breaker opens. Any enabled trigger opens it; rate
+// triggers are evaluated over a rolling window once it has seen MinCalls.
+type Config struct {
+	ConsecutiveFailures int           // open after this many failures in a row; 0 disables
+	Cooldown            time.Duration // how long to stay open before probing
+
+	Window    time.Duration // rolling window of the rate triggers
+	MinCalls  int           // calls in the window before rates are judged
+	ErrorRate float64       // open when this fraction of calls fail; 0 disables
+	SlowCall  time.Duration // calls slower than this count as slow
+	SlowRate  float64       // open when this fraction of calls are slow; 0 disables
+
+	// OnStateChange, if set, is called with the breaker locked after every
+	// transition. It must not call back into the breaker.
+	OnStateChange func(from, to State)
+}
+
+const windowBuckets = 10
+
+// bucket counts the calls of one slice of the rolling window.
+type bucket struct {
+	slot                  int64 // window slice this bucket currently holds
+	calls, failures, slow int
+}
+
+// Breaker is a closed/open/half-open circuit breaker. It opens on
+// consecutive failures, a high error rate or a high rate of slow calls, and
+// lets one probe through after the cooldown.
+type Breaker struct {
+	mu       sync.Mutex
+	cfg      Config
+	state    State
+	failures int // consecutive failures while closed
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+	buckets  [windowBuckets]bucket
+}
+
+// New returns a breaker that opens after threshold consecutive failures.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return NewWithConfig(Config{ConsecutiveFailures: threshold, Cooldown: cooldown})
+}
+
+func NewWithConfig(cfg Config) *Breaker {
+	if cfg.MinCalls <= 0 {
+		cfg.MinCalls = 1
+	}
+	return &Breaker{cfg: cfg}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by exactly one Record or Observe.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cfg.Cooldown {
+			return ErrOpen
+		}
+		b.setState(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Ready reports whether Allow would let a call through, without starting a
+// probe.
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		return time.Since(b.openedAt) >= b.cfg.Cooldown
+	case HalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// Record reports the outcome of a call let through by Allow.
+func (b *Breaker) Record(err error) {
+	b.Observe(err, 0)
+}
+
+// Observe reports the outcome and latency of a call let through by Allow.
+func (b *Breaker) Observe(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	slow := b.cfg.SlowCall > 0 && latency > b.cfg.SlowCall
+	if b.state == HalfOpen {
+		b.probing = false
+		if err != nil || slow {
+			b.trip()
+		} else {
+			b.reset()
+			b.setState(Closed)
+		}
+		return
+	}
+	if b.state == Open {
+		return // a call from before the circuit opened
+	}
+
+	bk := b.bucket(time.Now())
+	bk.calls++
+	if slow {
+		bk.slow++
+	}
+	if err == nil {
+		b.failures = 0
+	} else {
+		bk.failures++
+		b.failures++
+	}
+	if b.shouldTrip() {
+		b.trip()
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// shouldTrip checks the triggers. b.mu must be held.
+func (b *Breaker) shouldTrip() bool {
+	if n := b.cfg.ConsecutiveFailures; n > 0 && b.failures >= n {
+		return true
+	}
+	if b.cfg.Window <= 0 || (b.cfg.ErrorRate <= 0 && b.cfg.SlowRate <= 0) {
+		return false
+	}
+	var calls, failures, slow int
+	oldest := b.slot(time.Now()) - windowBuckets
+	for _, bk := range b.buckets {
+		if bk.slot > oldest {
+			calls += bk.calls
+			failures += bk.failures
+			slow += bk.slow
+		}
+	}
+	if calls < b.cfg.MinCalls {
+		return false
+	}
+	if b.cfg.ErrorRate > 0 && float64(failures) >= b.cfg.ErrorRate*float64(calls) {
+		return true
+	}
+	return b.cfg.SlowRate > 0 && float64(slow) >= b.cfg.SlowRate*float64(calls)
+}
+
+// slot numbers the window slice containing t.
+func (b *Breaker) slot(t time.Time) int64 {
+	width := int64(b.cfg.Window / windowBuckets)
+	if width <= 0 {
+		return 0
+	}
+	return t.UnixNano() / width
+}
+
+// bucket returns the bucket for t, clearing it if it held an older slice.
+// b.mu must be held.
+func (b *Breaker) bucket(t time.Time) *bucket {
+	slot := b.slot(t)
+	bk := &b.buckets[slot%windowBuckets]
+	if bk.slot != slot {
+		*bk = bucket{slot: slot}
+	}
+	return bk
+}
+
+// trip opens the circuit. b.mu must be held.
+func (b *Breaker) trip() {
+	b.setState(Open)
+	b.openedAt = time.Now()
+	b.reset()
+}
+
+// reset forgets past calls. b.mu must be held.
+func (b *Breaker) reset() {
+	b.failures = 0
+	b.buckets = [windowBuckets]bucket{}
+}
+
+// setState moves to s and reports the transition. b.mu must be held.
+func (b *Breaker) setState(s State) {
+	if s == b.state {
+		return
+	}
+	from := b.state
+	b.state = s
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, s)
+	}
+}
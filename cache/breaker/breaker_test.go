@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := New(2, 10*time.Millisecond)
+	fail := errors.New("fail")
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("closed breaker rejected call %d", i)
+		}
+		b.Record(fail)
+	}
+	if b.State() != Open || b.Allow() != ErrOpen {
+		t.Fatalf("breaker should be open after 2 failures, got %s", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Allow(); err != nil || b.State() != HalfOpen {
+		t.Fatalf("breaker should let a probe through after cooldown")
+	}
+	if b.Allow() != ErrOpen {
+		t.Fatalf("only one probe should be let through")
+	}
+	b.Record(nil)
+	if b.State() != Closed {
+		t.Fatalf("successful probe should close the breaker, got %s", b.State())
+	}
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	var transitions []State
+	b := NewWithConfig(Config{
+		Cooldown:  time.Minute,
+		Window:    time.Minute,
+		MinCalls:  10,
+		ErrorRate: 0.5,
+		OnStateChange: func(from, to State) {
+			transitions = append(transitions, to)
+		},
+	})
+	fail := errors.New("fail")
+
+	// Alternating outcomes never reach a run of failures, but half fail.
+	for i := 0; i < 9; i++ {
+		b.Allow()
+		if i%2 == 1 {
+			b.Record(fail)
+		} else {
+			b.Record(nil)
+		}
+	}
+	if b.State() != Closed {
+		t.Fatalf("breaker should wait for %d calls, got %s", 10, b.State())
+	}
+	b.Allow()
+	b.Record(nil)
+	if b.State() != Closed {
+		t.Fatalf("4 of 10 failed, breaker should stay closed, got %s", b.State())
+	}
+	b.Allow()
+	b.Record(fail)
+	if b.State() != Closed {
+		t.Fatalf("5 of 11 failed, breaker should stay closed, got %s", b.State())
+	}
+	b.Allow()
+	b.Record(fail)
+	if b.State() != Open || b.Ready() {
+		t.Fatalf("6 of 12 failed, breaker should open, got %s", b.State())
+	}
+	if len(transitions) != 1 || transitions[0] != Open {
+		t.Fatalf("expected a single transition to open, got %v", transitions)
+	}
+}
+
+func TestBreakerSlowCalls(t *testing.T) {
+	b := NewWithConfig(Config{
+		Cooldown: 10 * time.Millisecond,
+		Window:   time.Minute,
+		MinCalls: 4,
+		SlowCall: 100 * time.Millisecond,
+		SlowRate: 0.75,
+	})
+	for i := 0; i < 4; i++ {
+		b.Allow()
+		b.Observe(nil, 200*time.Millisecond)
+	}
+	if b.State() != Open {
+		t.Fatalf("slow calls should open the breaker, got %s", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.Ready() || b.Allow() != nil {
+		t.Fatal("breaker should let a probe through after cooldown")
+	}
+	if b.Ready() {
+		t.Fatal("breaker should not be ready while the probe is in flight")
+	}
+	b.Observe(nil, 200*time.Millisecond)
+	if b.State() != Open {
+		t.Fatalf("slow probe should reopen the breaker, got %s", b.State())
+	}
+}
@@ -1,26 +1,84 @@
 package cache
 
+import (
+	"bytes"
+	"io"
+)
+
 type ByteView struct {
-	bytes []byte
+	bytes  []byte
+	chunks [][]byte // large values, in place of bytes
+	stale  bool
 }
 
 func (bv ByteView) Len() int {
-	return len(bv.bytes)
+	if bv.chunks == nil {
+		return len(bv.bytes)
+	}
+	n := 0
+	for _, c := range bv.chunks {
+		n += len(c)
+	}
+	return n
 }
 
 func (bv ByteView) Bytes() []byte {
 	return bv.cloneBytes()
 }
 
+// Stale reports whether the value was served past its TTL because a reload failed.
+func (bv ByteView) Stale() bool {
+	return bv.stale
+}
+
 func (bv ByteView) String() string {
-	return string(bv.bytes)
+	return string(bv.flat())
+}
+
+// Reader returns a reader over the value that does not copy it.
+func (bv ByteView) Reader() io.Reader {
+	if bv.chunks == nil {
+		return bytes.NewReader(bv.bytes)
+	}
+	readers := make([]io.Reader, len(bv.chunks))
+	for i, c := range bv.chunks {
+		readers[i] = bytes.NewReader(c)
+	}
+	return io.MultiReader(readers...)
+}
+
+// flat returns the value as one slice, joining the chunks of a large value.
+// The result must not be modified.
+func (bv ByteView) flat() []byte {
+	if bv.chunks == nil {
+		return bv.bytes
+	}
+	return bytes.Join(bv.chunks, nil)
+}
+
+// chunked returns bv split into chunks if it is larger than one, so large
+// values are held in pieces. The chunks share bv's bytes.
+func (bv ByteView) chunked() ByteView {
+	if bv.chunks != nil || len(bv.bytes) <= chunkSize {
+		return bv
+	}
+	chunks := make([][]byte, 0, (len(bv.bytes)+chunkSize-1)/chunkSize)
+	for p := bv.bytes; len(p) > 0; {
+		n := min(len(p), chunkSize)
+		chunks = append(chunks, p[:n:n])
+		p = p[n:]
+	}
+	return ByteView{chunks: chunks, stale: bv.stale}
 }
 
 func (bv ByteView) cloneBytes() []byte {
+	if bv.chunks != nil {
+		return bv.flat() // already a fresh copy
+	}
 	if bv.bytes == nil {
 		return nil
 	}
 	cloned := make([]byte, len(bv.bytes))
 	copy(cloned, bv.bytes)
 	return cloned
-}
\ No newline at end of file
+}
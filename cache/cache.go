@@ -3,12 +3,32 @@ package cache
 import (
 	lru "distributed-cache/cache/lru_cache"
 	"sync"
+	"time"
 )
 
+// nowFunc is swapped out in tests.
+var nowFunc = time.Now
+
 type Cache struct {
-	mu        sync.Mutex
-	lruCache  *lru.LRUCache
-	cacheSize int64
+	mu         sync.Mutex
+	lruCache   *lru.LRUCache
+	cacheSize  int64
+	ttl        time.Duration // zero means entries never expire
+	staleGrace time.Duration // how long expired entries are kept for stale serving
+	compressor Compressor    // compresses stored values, if set
+}
+
+// entry is what the LRU actually stores: the value plus its freshness.
+type entry struct {
+	value  ByteView
+	expire time.Time     // zero means the entry never expires
+	delta  time.Duration // how long the load that produced value took
+
+	compressed bool // value is held compressed with the cache's compressor
+}
+
+func (e *entry) Len() int {
+	return e.value.Len()
 }
 
 func NewCache(size int64, onEvicted func(key string, value lru.Value)) *Cache {
@@ -18,17 +38,157 @@ func NewCache(size int64, onEvicted func(key string, value lru.Value)) *Cache {
 	}
 }
 
+// Get returns the value for key if it is present and not expired.
 func (c *Cache) Get(key string) (value ByteView, exists bool) {
+	e, exists := c.getEntry(key)
+	return e.value, exists
+}
+
+// getEntry is Get but also returns the expiry and load time of the value.
+func (c *Cache) getEntry(key string) (e entry, exists bool) {
+	c.mu.Lock()
+	found, exists := c.lookup(key)
+	if !exists || c.expired(found, nowFunc()) {
+		c.mu.Unlock()
+		return entry{}, false
+	}
+	e = *found
+	c.mu.Unlock()
+	return c.decompress(e)
+}
+
+// GetStale returns an expired value that is still inside the stale grace
+// window. The returned view reports Stale() == true.
+func (c *Cache) GetStale(key string) (value ByteView, exists bool) {
+	c.mu.Lock()
+	found, exists := c.lookup(key)
+	if !exists {
+		c.mu.Unlock()
+		return ByteView{}, false
+	}
+	e := *found
+	stale := c.expired(found, nowFunc())
+	c.mu.Unlock()
+	if e, exists = c.decompress(e); !exists {
+		return ByteView{}, false
+	}
+	value = e.value
+	value.stale = stale
+	return value, true
+}
+
+// decompress returns e with its value decompressed. A value that fails to
+// decompress is reported as missing.
+func (c *Cache) decompress(e entry) (entry, bool) {
+	if !e.compressed {
+		return e, true
+	}
+	if e.value.chunks == nil {
+		data, err := c.compressor.Decompress(e.value.bytes, c.cacheSize)
+		if err != nil {
+			return entry{}, false
+		}
+		e.value.bytes, e.compressed = data, false
+		return e, true
+	}
+	chunks := make([][]byte, len(e.value.chunks))
+	for i, chunk := range e.value.chunks {
+		data, err := c.compressor.Decompress(chunk, chunkSize)
+		if err != nil {
+			return entry{}, false
+		}
+		chunks[i] = data
+	}
+	e.value.chunks, e.compressed = chunks, false
+	return e, true
+}
+
+// compress returns value compressed with the cache's compressor, chunk by
+// chunk for a chunked value, and whether that made it smaller.
+func (c *Cache) compress(value ByteView) (ByteView, bool) {
+	if value.chunks == nil {
+		data, err := c.compressor.Compress(value.bytes)
+		if err != nil || len(data) >= len(value.bytes) {
+			return value, false
+		}
+		return ByteView{bytes: data}, true
+	}
+	chunks := make([][]byte, len(value.chunks))
+	size := 0
+	for i, chunk := range value.chunks {
+		data, err := c.compressor.Compress(chunk)
+		if err != nil {
+			return value, false
+		}
+		chunks[i], size = data, size+len(data)
+	}
+	if size >= value.Len() {
+		return value, false
+	}
+	return ByteView{chunks: chunks}, true
+}
+
+func (c *Cache) Add(key string, value ByteView) {
+	c.addEntry(key, value, 0)
+}
+
+// addEntry adds value and records delta, the time it took to load. Values
+// larger than a chunk are held in chunks.
+func (c *Cache) addEntry(key string, value ByteView, delta time.Duration) {
+	value = value.chunked()
+	e := &entry{value: value, delta: delta}
+	if c.compressor != nil {
+		e.value, e.compressed = c.compress(value)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		e.expire = nowFunc().Add(c.ttl)
+	}
+	c.lruCache.Add(key, e)
+}
+
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lruCache.Remove(key)
+}
+
+// Contains reports whether key is held, fresh or stale, without affecting
+// its recency.
+func (c *Cache) Contains(key string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if value, exists := c.lruCache.Get(key); exists {
-		return value.(ByteView), exists
+	v, exists := c.lruCache.Peek(key)
+	if !exists {
+		return false
 	}
-	return
+	e := v.(*entry)
+	return e.expire.IsZero() || !nowFunc().After(e.expire.Add(c.staleGrace))
 }
 
-func (c *Cache) Add(key string, value lru.Value) {
+// Stats returns the number of entries held and the bytes they use.
+func (c *Cache) Stats() (keys int, bytes int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.lruCache.Add(key, value)
+	return c.lruCache.Len(), c.lruCache.Size()
+}
+
+// lookup finds key in the LRU and drops it if it is past its grace window.
+// c.mu must be held.
+func (c *Cache) lookup(key string) (*entry, bool) {
+	v, exists := c.lruCache.Get(key)
+	if !exists {
+		return nil, false
+	}
+	e := v.(*entry)
+	if !e.expire.IsZero() && nowFunc().After(e.expire.Add(c.staleGrace)) {
+		c.lruCache.Remove(key)
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *Cache) expired(e *entry, now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
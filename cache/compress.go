@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+)
+
+// Compressor compresses peer payloads and stored values. Name is the HTTP
+// content coding it implements, e.g. "gzip". Decompress must fail with
+// ErrTooLarge rather than produce more than limit bytes.
+type Compressor interface {
+	Name() string
+	Compress(p []byte) ([]byte, error)
+	Decompress(p []byte, limit int64) ([]byte, error)
+}
+
+// ErrTooLarge is returned for payloads that decompress to more bytes than
+// allowed.
+var ErrTooLarge = errors.New("decompressed payload too large")
+
+// Gzip and Deflate are the standard library codings. Deflate is the zlib
+// format, as the HTTP "deflate" coding specifies.
+var (
+	Gzip    Compressor = gzipCompressor{}
+	Deflate Compressor = deflateCompressor{}
+)
+
+const (
+	defaultCompressionThreshold = 1 << 10
+	// maxPayloadOverhead allows for the group, key and framing around a
+	// value in a peer payload.
+	maxPayloadOverhead = 4 << 10
+	// maxPayload bounds payloads for groups this node does not have.
+	maxPayload = 64 << 20
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		Gzip.Name():    Gzip,
+		Deflate.Name(): Deflate,
+	}
+)
+
+// RegisterCompressor makes c available for peer traffic under its name. It
+// must be registered on every node before any pool prefers it.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+func compressorFor(name string) (Compressor, bool) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// WithCompression compresses peer payloads larger than threshold bytes
+// (default 1KiB) with the first of codings the other side accepts; the
+// default is gzip then deflate. Responses are only compressed for peers that
+// ask for it, so nodes with and without compression interoperate. Requests,
+// however, are sent compressed with codings[0] without asking the peer, so
+// every node must support it: enable compression on all nodes, or register
+// the coding everywhere, before any node lists it first. Values large enough
+// to be streamed are compressed chunk by chunk.
+func WithCompression(threshold int, codings ...string) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		if threshold <= 0 {
+			threshold = defaultCompressionThreshold
+		}
+		if len(codings) == 0 {
+			codings = []string{Gzip.Name(), Deflate.Name()}
+		}
+		p.compressThreshold = threshold
+		p.codings = codings
+	}
+}
+
+// WithGRPCCompression gzips the pool's peer calls. Servers reply with the
+// coding of the call, and gRPC's message size limit bounds what a payload
+// may decompress to.
+func WithGRPCCompression() GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.dialOpts = append(p.dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+	}
+}
+
+// negotiate picks the first of the pool's codings listed in an
+// Accept-Encoding header, or nil.
+func (p *HTTPPool) negotiate(accept string) Compressor {
+	if accept == "" {
+		return nil
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if acceptable(params) {
+			accepted[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+	for _, name := range p.codings {
+		if accepted[name] {
+			if c, ok := compressorFor(name); ok {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// acceptable reports whether the parameters of an Accept-Encoding entry
+// accept the coding: they have no q value, or a non-zero one. Any spelling of
+// zero, such as "q=0.000", is a refusal, and so is a malformed q value.
+func acceptable(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil && q > 0
+	}
+	return true
+}
+
+// encodeBody compresses body with c if it is large enough, and reports the
+// coding used, "" for none.
+func encodeBody(c Compressor, threshold int, body []byte) ([]byte, string, error) {
+	if c == nil || len(body) < threshold {
+		return body, "", nil
+	}
+	compressed, err := c.Compress(body)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(compressed) >= len(body) {
+		return body, "", nil
+	}
+	return compressed, c.Name(), nil
+}
+
+// payloadLimit is the largest peer payload accepted for group: its biggest
+// possible value plus overhead.
+func payloadLimit(group string) int64 {
+	if g := GetGroup(group); g != nil {
+		return g.cache.cacheSize + maxPayloadOverhead
+	}
+	return maxPayload
+}
+
+// readLimited reads r, failing with ErrTooLarge past limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// decodeBody undoes the content coding of a peer payload, failing if it
+// decodes to more than limit bytes.
+func decodeBody(header http.Header, body []byte, limit int64) ([]byte, error) {
+	coding := header.Get("Content-Encoding")
+	if coding == "" || coding == "identity" {
+		return body, nil
+	}
+	c, ok := compressorFor(coding)
+	if !ok {
+		return nil, fmt.Errorf("unsupported content encoding %q", coding)
+	}
+	return c.Decompress(body, limit)
+}
+
+type gzipCompressor struct{}
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+	return w
+}}
+
+func (gzipCompressor) Name() string { return "gzip" }
+
+func (gzipCompressor) Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(p []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+type deflateCompressor struct{}
+
+var zlibWriters = sync.Pool{New: func() interface{} {
+	w, _ := zlib.NewWriterLevel(nil, flate.BestSpeed)
+	return w
+}}
+
+func (deflateCompressor) Name() string { return "deflate" }
+
+func (deflateCompressor) Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlibWriters.Get().(*zlib.Writer)
+	defer zlibWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCompressor) Decompress(p []byte, limit int64) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+// WithCompressedValues keeps the group's values compressed with c in its
+// cache, so the cache's byte budget counts compressed sizes. Values are
+// decompressed on every read; values that do not shrink are kept as they are.
+// Each read returns a fresh copy, so a TypedGroup over the group does not
+// use its object cache.
+func WithCompressedValues(c Compressor) GroupOption {
+	return func(g *Group) {
+		g.cache.compressor = c
+	}
+}
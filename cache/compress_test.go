@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	pb "distributed-cache/cache/pb"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"value"},`), 100)
+	for _, c := range []Compressor{Gzip, Deflate} {
+		compressed, err := c.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) >= len(data) {
+			t.Fatalf("%s did not shrink repetitive data", c.Name())
+		}
+		got, err := c.Decompress(compressed, int64(len(data)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s round trip failed: %v", c.Name(), err)
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for _, c := range []Compressor{Gzip, Deflate} {
+		compressed, err := c.Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Decompress(compressed, int64(len(data)-1)); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%s: expected ErrTooLarge, got %v", c.Name(), err)
+		}
+	}
+}
+
+func TestCompressedPostBounded(t *testing.T) {
+	NewGroup("compressed-bomb", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("unused")
+	}))
+	pool := NewHTTPPool("http://self", WithCompression(0))
+	body, err := proto.Marshal(&pb.SetRequest{Group: "compressed-bomb", Key: "k", Value: make([]byte, 1<<20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := Gzip.Compress(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, defaultPath+"compressed-bomb/k", bytes.NewReader(compressed))
+	req.Header.Set("Content-Encoding", Gzip.Name())
+	req.Header.Set(ringFormatHeader, pool.format)
+	rec := httptest.NewRecorder()
+	pool.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for a payload past the cache size, got %d", rec.Code)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	pool := NewHTTPPool("http://a", WithCompression(0))
+	tests := map[string]string{
+		"":                       "",
+		"br":                     "",
+		"deflate, gzip":          "gzip",
+		"gzip;q=0, deflate":      "deflate",
+		"gzip;q=0.0, deflate":    "deflate",
+		"gzip; q=0.000, deflate": "deflate",
+		"gzip;q=oops, deflate":   "deflate",
+		"gzip;Q=0.001":           "gzip",
+		"GZIP;q=0.5":             "gzip",
+		"identity, deflate;q=1":  "deflate",
+	}
+	for accept, want := range tests {
+		got := ""
+		if c := pool.negotiate(accept); c != nil {
+			got = c.Name()
+		}
+		if got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func TestCompressedPeerTraffic(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		encoding = make(map[string]string) // method -> coding seen on the wire
+	)
+	capture := WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := base.RoundTrip(r)
+			if isAdmin(r) {
+				return res, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method == http.MethodPost {
+				encoding[r.Method] = r.Header.Get("Content-Encoding")
+			} else if err == nil {
+				encoding[r.Method] = res.Header.Get("Content-Encoding")
+			}
+			return res, err
+		})
+	})
+	pools := startPools(t, 2, WithCompression(64, "deflate", "gzip"), capture)
+	value := strings.Repeat("large json blob ", 100)
+	group := NewGroup("compressed-wire", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}))
+	peer := pools[0].state.Load().httpGetters[pools[1].self]
+
+	var res pb.Response
+	if err := peer.Get(&pb.GetRequest{Group: "compressed-wire", Key: "k"}, &res); err != nil || string(res.Value) != value {
+		t.Fatalf("compressed get failed: %v", err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "compressed-wire", Key: "s", Value: []byte(value)}, &pb.EmptyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := group.cache.Get("s"); !ok || v.String() != value {
+		t.Fatal("compressed set not applied")
+	}
+	mu.Lock()
+	if encoding[http.MethodGet] != "deflate" || encoding[http.MethodPost] != "deflate" {
+		t.Fatalf("expected deflate on the wire, got %v", encoding)
+	}
+	mu.Unlock()
+
+	// A peer that does not ask for compression gets plain responses.
+	plain := NewHTTPPool("http://plain", capture)
+	plain.Set(pools[1].self)
+	if err := plain.state.Load().httpGetters[pools[1].self].Get(&pb.GetRequest{Group: "compressed-wire", Key: "k"}, &res); err != nil || string(res.Value) != value {
+		t.Fatalf("plain get failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if encoding[http.MethodGet] != "" {
+		t.Fatalf("expected an uncompressed response, got %q", encoding[http.MethodGet])
+	}
+}
+
+func TestCompressedValues(t *testing.T) {
+	value := strings.Repeat("large json blob ", 100)
+	group := NewGroup("compressed-values", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}), WithCompressedValues(Gzip))
+
+	view, err := group.Get("k")
+	if err != nil || view.String() != value {
+		t.Fatalf("expected the decompressed value, got %v", err)
+	}
+	if _, size := group.cache.Stats(); size >= int64(len(value)) {
+		t.Fatalf("cache should count the compressed size, got %d of %d bytes", size, len(value))
+	}
+	if view, ok := group.cache.Get("k"); !ok || view.String() != value {
+		t.Fatal("cache read should decompress")
+	}
+
+	// Chunked values are compressed chunk by chunk and stay chunked.
+	chunked := ByteView{chunks: [][]byte{[]byte(value), []byte(value)}}
+	group.cache.Add("chunked", chunked)
+	if v, _ := group.cache.lruCache.Peek("chunked"); len(v.(*entry).value.chunks) != 2 || v.(*entry).Len() >= chunked.Len() {
+		t.Fatal("chunked value should be held as compressed chunks")
+	}
+	if view, ok := group.cache.Get("chunked"); !ok || len(view.chunks) != 2 || view.String() != value+value {
+		t.Fatal("chunked value did not read back")
+	}
+
+	// Values that do not shrink are kept as they are.
+	group.cache.Add("small", ByteView{bytes: []byte("x")})
+	if view, ok := group.cache.Get("small"); !ok || view.String() != "x" {
+		t.Fatal("small value lost")
+	}
+}
+
+func TestCompressedValuesSkipObjectCache(t *testing.T) {
+	group := NewGroup("compressed-typed", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return json.Marshal(strings.Repeat("value ", 100))
+	}), WithCompressedValues(Gzip))
+	tg := NewTypedGroup[string](group, JSONCodec[string]{}, 1<<10)
+	if tg.objects != nil {
+		t.Fatal("compressed groups should not use the object cache")
+	}
+	if v, err := tg.Get("k"); err != nil || len(v) != 600 {
+		t.Fatalf("typed get failed: %d bytes, %v", len(v), err)
+	}
+}
+
+// payloadSizes records the sizes of payloads a gRPC client receives.
+type payloadSizes struct {
+	mu                     sync.Mutex
+	length, compressedSize int
+}
+
+func (*payloadSizes) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+func (*payloadSizes) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+func (*payloadSizes) HandleConn(context.Context, stats.ConnStats) {}
+
+func (p *payloadSizes) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if in, ok := s.(*stats.InPayload); ok {
+		p.mu.Lock()
+		p.length, p.compressedSize = in.Length, in.CompressedLength
+		p.mu.Unlock()
+	}
+}
+
+func TestGRPCCompression(t *testing.T) {
+	value := strings.Repeat("large json blob ", 100)
+	NewGroup("grpc-compressed", 4<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	}))
+	sizes := &payloadSizes{}
+	pools := startGRPCPools(t, 2, WithGRPCCompression(), WithDialOptions(grpc.WithStatsHandler(sizes)))
+
+	var res pb.Response
+	if err := pools[0].state.Load().clients[pools[1].self].Get(&pb.GetRequest{Group: "grpc-compressed", Key: "k"}, &res); err != nil || string(res.Value) != value {
+		t.Fatalf("compressed call failed: %v", err)
+	}
+	sizes.mu.Lock()
+	defer sizes.mu.Unlock()
+	if sizes.compressedSize >= sizes.length {
+		t.Fatalf("response was not compressed: %d of %d bytes", sizes.compressedSize, sizes.length)
+	}
+}
@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -11,15 +12,17 @@ type Hash func(data []byte) uint32
 type Map struct {
 	hash     Hash
 	replicas int
-	keys     []int          // virtual nodes
-	hashMap  map[int]string // vitrual node, actual key
+	keys     []int            // virtual nodes, sorted and unique
+	hashMap  map[int][]string // virtual node, actual keys (sorted, first one owns it)
+	nodes    map[string]int   // actual key, number of virtual nodes
 }
 
 func NewMap(replicas int, fn Hash) *Map {
 	m := &Map{
 		hash:     fn,
 		replicas: replicas,
-		hashMap:  make(map[int]string),
+		hashMap:  make(map[int][]string),
+		nodes:    make(map[string]int),
 	}
 	if m.hash == nil {
 		m.hash = crc32.ChecksumIEEE
@@ -27,52 +30,276 @@ func NewMap(replicas int, fn Hash) *Map {
 	return m
 }
 
-// Add "real" nodes (replicas)
+// Replication returns the number of virtual nodes a node of weight 1 has.
+func (m *Map) Replication() int {
+	return m.replicas
+}
+
+// Add "real" nodes (replicas). Adding a node that is already present is a
+// no-op.
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
+		if _, exists := m.nodes[key]; exists {
+			continue
+		}
+		m.nodes[key] = m.replicas
 		for i := 0; i < m.replicas; i++ {
-			offset := strconv.Itoa(i)
-			hash := int(m.hash([]byte(offset + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+			m.addVnode(m.vnodeHash(key, i), key)
 		}
 	}
 	sort.Ints(m.keys)
 }
 
+// AddWeighted adds node with weight times the default number of virtual
+// nodes, so its share of the keyspace scales with weight. Calling it again
+// for an existing node changes its weight, moving only the keys of the
+// virtual nodes added or dropped.
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight <= 0 {
+		m.Remove(key)
+		return
+	}
+	count := m.replicas * weight
+	current := m.nodes[key]
+	m.nodes[key] = count
+	for i := current; i < count; i++ {
+		m.addVnode(m.vnodeHash(key, i), key)
+	}
+	sort.Ints(m.keys)
+	if count < current {
+		for i := count; i < current; i++ {
+			m.removeVnode(m.vnodeHash(key, i), key)
+		}
+		m.compact()
+	}
+}
+
+// Remove drops exactly the virtual nodes that belong to the given nodes.
+func (m *Map) Remove(keys ...string) {
+	for _, key := range keys {
+		count, exists := m.nodes[key]
+		if !exists {
+			continue
+		}
+		delete(m.nodes, key)
+		for i := 0; i < count; i++ {
+			m.removeVnode(m.vnodeHash(key, i), key)
+		}
+	}
+	m.compact()
+}
+
+// compact drops virtual nodes that lost all their owners from m.keys.
+func (m *Map) compact() {
+	live := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, exists := m.hashMap[hash]; exists {
+			live = append(live, hash)
+		}
+	}
+	m.keys = live
+}
+
+// SetNodes replaces the membership with nodes. Nodes already present keep
+// their weight and virtual nodes; new ones are added with weight 1.
+func (m *Map) SetNodes(nodes ...string) {
+	wanted := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		wanted[node] = true
+	}
+	for node := range m.nodes {
+		if !wanted[node] {
+			m.Remove(node)
+		}
+	}
+	m.Add(nodes...)
+}
+
+// Owner is Get; together with Replicas, SetNodes and Clone it lets Map back
+// a placement.Ring.
+func (m *Map) Owner(key string) string {
+	return m.Get(key)
+}
+
+func (m *Map) Replicas(key string, n int) []string {
+	return m.GetReplicas(key, n)
+}
+
+// Clone returns a deep copy of the ring.
+func (m *Map) Clone() *Map {
+	clone := &Map{
+		hash:     m.hash,
+		replicas: m.replicas,
+		keys:     append([]int(nil), m.keys...),
+		hashMap:  make(map[int][]string, len(m.hashMap)),
+		nodes:    make(map[string]int, len(m.nodes)),
+	}
+	for hash, owners := range m.hashMap {
+		clone.hashMap[hash] = append([]string(nil), owners...)
+	}
+	for node, count := range m.nodes {
+		clone.nodes[node] = count
+	}
+	return clone
+}
+
+// Nodes returns the current members in sorted order.
+func (m *Map) Nodes() []string {
+	nodes := make([]string, 0, len(m.nodes))
+	for node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Weight returns the number of virtual nodes of node relative to the
+// default, or 0 if node is not a member.
+func (m *Map) Weight(node string) int {
+	return m.nodes[node] / m.replicas
+}
+
+// VirtualNodes returns how many virtual nodes node has on the ring.
+func (m *Map) VirtualNodes(node string) int {
+	return m.nodes[node]
+}
+
+// Shares returns the fraction of the hash space each node owns as primary.
+// With a good hash this is the expected fraction of keys it receives.
+func (m *Map) Shares() map[string]float64 {
+	shares := make(map[string]float64, len(m.nodes))
+	if len(m.keys) == 0 {
+		return shares
+	}
+	const space = float64(1 << 32)
+	prev := m.keys[len(m.keys)-1] - (1 << 32) // wrap around the ring
+	for _, hash := range m.keys {
+		shares[m.hashMap[hash][0]] += float64(hash-prev) / space
+		prev = hash
+	}
+	return shares
+}
+
 // Given a key, return the primary node the key belong to
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
-	hash := int(m.hash([]byte(key)))
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
-	})
+	idx := m.search(key)
+	return m.hashMap[m.keys[idx%len(m.keys)]][0]
+}
 
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+// GetBounded implements consistent hashing with bounded loads (Mirrokni,
+// Thorup and Zadimoghaddam). Each node may carry at most
+// ceil((1+epsilon) * average) load, counting the request being placed; a node
+// at capacity is skipped and the key goes to the next node on the ring. load
+// reports a node's current load, e.g. its in-flight requests.
+func (m *Map) GetBounded(key string, epsilon float64, load func(node string) int64) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	var total int64 = 1 // the request being placed
+	for node := range m.nodes {
+		total += load(node)
+	}
+	capacity := int64(math.Ceil((1 + epsilon) * float64(total) / float64(len(m.nodes))))
+
+	idx := m.search(key)
+	seen := make(map[string]bool, len(m.nodes))
+	for i := 0; len(seen) < len(m.nodes); i++ {
+		for _, node := range m.hashMap[m.keys[(idx+i)%len(m.keys)]] {
+			if seen[node] {
+				continue
+			}
+			seen[node] = true
+			if load(node)+1 <= capacity {
+				return node
+			}
+		}
+	}
+	// Unreachable: the average is at most capacity, so some node fits.
+	return m.Get(key)
 }
 
+// GetReplicas returns the first rf distinct nodes clockwise from key. With
+// fewer than rf nodes it returns all of them.
 func (m *Map) GetReplicas(key string, rf int) []string {
 	if len(m.keys) == 0 || rf <= 0 {
 		return nil
 	}
-	hash := int(m.hash([]byte(key)))
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
-	})
+	return m.replicasFrom(m.search(key), rf)
+}
 
+// replicasFrom walks the ring from virtual node idx collecting rf distinct
+// nodes, or every node if there are fewer.
+func (m *Map) replicasFrom(idx int, rf int) []string {
+	if rf > len(m.nodes) {
+		rf = len(m.nodes)
+	}
 	var (
 		replicas []string
 		seen     = make(map[string]bool)
 	)
 	for i := 0; len(replicas) < rf; i++ {
-		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
-		if !seen[node] {
-			replicas = append(replicas, node)
-			seen[node] = true
+		for _, node := range m.hashMap[m.keys[(idx+i)%len(m.keys)]] {
+			if !seen[node] && len(replicas) < rf {
+				replicas = append(replicas, node)
+				seen[node] = true
+			}
 		}
 	}
 
 	return replicas
 }
+
+func (m *Map) vnodeHash(key string, i int) int {
+	// Build "<index><node>" in one buffer rather than through strings.
+	var buf [64]byte
+	b := append(strconv.AppendInt(buf[:0], int64(i), 10), key...)
+	return int(m.hash(b))
+}
+
+// search returns the index of the first virtual node at or after key's hash.
+func (m *Map) search(key string) int {
+	return m.searchHash(int(m.hash([]byte(key))))
+}
+
+func (m *Map) searchHash(hash int) int {
+	return sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+}
+
+// addVnode places node at hash. When two virtual nodes collide, both are kept
+// and ordered by name, so the owner does not depend on insertion order.
+// The caller re-sorts m.keys.
+func (m *Map) addVnode(hash int, node string) {
+	owners, exists := m.hashMap[hash]
+	if !exists {
+		m.keys = append(m.keys, hash)
+	}
+	idx := sort.SearchStrings(owners, node)
+	if idx < len(owners) && owners[idx] == node {
+		return
+	}
+	owners = append(owners, "")
+	copy(owners[idx+1:], owners[idx:])
+	owners[idx] = node
+	m.hashMap[hash] = owners
+}
+
+// removeVnode takes node off hash. The caller drops emptied hashes from
+// m.keys.
+func (m *Map) removeVnode(hash int, node string) {
+	owners := m.hashMap[hash]
+	idx := sort.SearchStrings(owners, node)
+	if idx == len(owners) || owners[idx] != node {
+		return
+	}
+	owners = append(owners[:idx], owners[idx+1:]...)
+	if len(owners) > 0 {
+		m.hashMap[hash] = owners
+		return
+	}
+	delete(m.hashMap, hash)
+}
@@ -1,6 +1,8 @@
 package consistenthash
 
 import (
+	"fmt"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -44,3 +46,166 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+func TestAddIdempotentAndRemove(t *testing.T) {
+	hash := NewMap(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+	hash.Add("4")
+	if len(hash.keys) != 9 || !reflect.DeepEqual(hash.Nodes(), []string{"2", "4", "6"}) {
+		t.Fatalf("adding an existing node should be a no-op, got %d vnodes", len(hash.keys))
+	}
+
+	// 4, 14, 24 go away; 23 now lands on 26 -> 6
+	hash.Remove("4")
+	if len(hash.keys) != 6 || hash.Get("23") != "6" || hash.Get("11") != "2" {
+		t.Fatalf("remove did not drop exactly node 4's vnodes")
+	}
+}
+
+func TestVnodeCollision(t *testing.T) {
+	// Every vnode hashes to the same point.
+	collide := func(key []byte) uint32 { return 42 }
+	a := NewMap(1, collide)
+	a.Add("b", "a")
+	b := NewMap(1, collide)
+	b.Add("a", "b")
+	if a.Get("x") != "a" || b.Get("x") != "a" {
+		t.Fatalf("collision owner should not depend on insertion order")
+	}
+	if !reflect.DeepEqual(a.GetReplicas("x", 2), []string{"a", "b"}) {
+		t.Fatalf("colliding node should still be a replica")
+	}
+	a.Remove("a")
+	if a.Get("x") != "b" {
+		t.Fatalf("removing one colliding node should leave the other")
+	}
+}
+
+func TestMinimalMovement(t *testing.T) {
+	const numKeys = 10000
+	nodes := make([]string, 10)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	m := NewMap(100, nil)
+	m.Add(nodes...)
+	before := make([]string, numKeys)
+	for i := range before {
+		before[i] = m.Get(fmt.Sprintf("key-%d", i))
+	}
+
+	// Adding an 11th node should move about 1/11 of the keys, all to it.
+	m.Add("node-new")
+	moved := 0
+	for i := range before {
+		if owner := m.Get(fmt.Sprintf("key-%d", i)); owner != before[i] {
+			moved++
+			if owner != "node-new" {
+				t.Fatalf("key moved between old nodes: %s -> %s", before[i], owner)
+			}
+		}
+	}
+	if frac := float64(moved) / numKeys; frac < 0.04 || frac > 0.16 {
+		t.Fatalf("expected ~1/11 of keys to move on add, got %.3f", frac)
+	}
+
+	// Removing it again should restore the original placement exactly.
+	m.Remove("node-new")
+	for i := range before {
+		if owner := m.Get(fmt.Sprintf("key-%d", i)); owner != before[i] {
+			t.Fatalf("key %d did not return to %s after remove", i, before[i])
+		}
+	}
+
+	// Removing an original node moves only its keys, about 1/10.
+	m.Remove("node-3")
+	moved = 0
+	for i := range before {
+		if owner := m.Get(fmt.Sprintf("key-%d", i)); owner != before[i] {
+			moved++
+			if before[i] != "node-3" {
+				t.Fatalf("key not owned by the removed node moved")
+			}
+		}
+	}
+	if frac := float64(moved) / numKeys; frac < 0.04 || frac > 0.16 {
+		t.Fatalf("expected ~1/10 of keys to move on remove, got %.3f", frac)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	m := NewMap(100, nil)
+	m.Add("small")
+	m.AddWeighted("large", 4)
+
+	shares := m.Shares()
+	if total := shares["small"] + shares["large"]; total < 0.999 || total > 1.001 {
+		t.Fatalf("shares should cover the whole ring, got %.4f", total)
+	}
+	if shares["large"] < 0.7 || shares["large"] > 0.9 {
+		t.Fatalf("weight 4 node should own about 80%% of the ring, got %.3f", shares["large"])
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[m.Get(fmt.Sprintf("key-%d", i))]++
+	}
+	if frac := float64(counts["large"]) / 10000; frac < 0.7 || frac > 0.9 {
+		t.Fatalf("weight 4 node should get about 80%% of keys, got %.3f", frac)
+	}
+
+	m.AddWeighted("large", 1)
+	if m.Weight("large") != 1 || len(m.keys) != 200 {
+		t.Fatalf("reweighting should drop the extra vnodes, got %d", len(m.keys))
+	}
+}
+
+func TestGetBounded(t *testing.T) {
+	m := NewMap(100, nil)
+	m.Add("a", "b", "c", "d")
+	loads := make(map[string]int64)
+	load := func(node string) int64 { return loads[node] }
+
+	if got := m.GetBounded("hot", 0.25, load); got != m.Get("hot") {
+		t.Fatalf("unloaded ring should pick the usual owner, got %s", got)
+	}
+
+	// Pile every request for one hot key onto the ring without finishing any.
+	for i := 0; i < 100; i++ {
+		loads[m.GetBounded("hot", 0.25, load)]++
+	}
+	capacity := int64(32) // ceil(1.25 * 100 / 4)
+	for node, l := range loads {
+		if l > capacity {
+			t.Fatalf("node %s carries %d, above the bound %d", node, l, capacity)
+		}
+	}
+	if len(loads) < 4 {
+		t.Fatalf("overflow should spill over to the other nodes, got %v", loads)
+	}
+}
+
+func TestGetReplicasSmallCluster(t *testing.T) {
+	m := NewMap(10, nil)
+	m.Add("only")
+	if got := m.GetReplicas("key", 3); !reflect.DeepEqual(got, []string{"only"}) {
+		t.Fatalf("one-node ring should return its node, got %v", got)
+	}
+	m.Add("second")
+	if got := m.GetReplicas("key", 3); len(got) != 2 {
+		t.Fatalf("two-node ring should return both nodes, got %v", got)
+	}
+}
+
+func TestXXHashRing(t *testing.T) {
+	m := NewMap(100, XXHash)
+	m.Add("a", "b", "c")
+	for node, share := range m.Shares() {
+		if share < 0.2 || share > 0.47 {
+			t.Fatalf("xxhash ring gives %s a share of %.3f", node, share)
+		}
+	}
+}
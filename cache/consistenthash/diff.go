@@ -0,0 +1,132 @@
+package consistenthash
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Range is a span of the hash ring: the hashes h with Start < h <= End. When
+// Start >= End the range wraps past the top of the ring.
+type Range struct {
+	Start uint32
+	End   uint32
+}
+
+// Contains reports whether hash falls inside r.
+func (r Range) Contains(hash uint32) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+// RangeMove is a range whose owner or replica set differs between two
+// memberships.
+type RangeMove struct {
+	Range
+	OldOwner    string
+	NewOwner    string
+	OldReplicas []string
+	NewReplicas []string
+}
+
+// Diff compares the ring for oldNodes with the ring for newNodes, both built
+// with m's hash function, vnode count and the weights of nodes m already
+// holds, and returns the ranges whose first rf replicas change, in ring
+// order. Adjacent ranges with the same change are merged.
+func (m *Map) Diff(oldNodes, newNodes []string, rf int) []RangeMove {
+	before, after := m.build(oldNodes), m.build(newNodes)
+
+	points := make([]int, 0, len(before.keys)+len(after.keys))
+	points = append(points, before.keys...)
+	points = append(points, after.keys...)
+	sort.Ints(points)
+	if len(points) == 0 {
+		return nil
+	}
+
+	var moves []RangeMove
+	prev := points[len(points)-1]
+	for i, point := range points {
+		if i > 0 && point == points[i-1] {
+			continue
+		}
+		oldReplicas := before.replicasAtHash(point, rf)
+		newReplicas := after.replicasAtHash(point, rf)
+		r := Range{Start: uint32(prev), End: uint32(point)}
+		prev = point
+		if reflect.DeepEqual(oldReplicas, newReplicas) {
+			continue
+		}
+		if n := len(moves); n > 0 && moves[n-1].End == r.Start &&
+			reflect.DeepEqual(moves[n-1].OldReplicas, oldReplicas) &&
+			reflect.DeepEqual(moves[n-1].NewReplicas, newReplicas) {
+			moves[n-1].End = r.End
+			continue
+		}
+		moves = append(moves, RangeMove{
+			Range:       r,
+			OldOwner:    first(oldReplicas),
+			NewOwner:    first(newReplicas),
+			OldReplicas: oldReplicas,
+			NewReplicas: newReplicas,
+		})
+	}
+	return moves
+}
+
+// Handoff sorts keys held by self by how a membership change affects them:
+// give lists keys self stops replicating, take lists keys self starts
+// replicating.
+func (m *Map) Handoff(self string, keys []string, oldNodes, newNodes []string, rf int) (give, take []string) {
+	before, after := m.build(oldNodes), m.build(newNodes)
+	for _, key := range keys {
+		hash := int(m.hash([]byte(key)))
+		wasReplica := contains(before.replicasAtHash(hash, rf), self)
+		isReplica := contains(after.replicasAtHash(hash, rf), self)
+		switch {
+		case wasReplica && !isReplica:
+			give = append(give, key)
+		case !wasReplica && isReplica:
+			take = append(take, key)
+		}
+	}
+	return give, take
+}
+
+// build returns a ring for nodes sharing m's settings and weights.
+func (m *Map) build(nodes []string) *Map {
+	ring := NewMap(m.replicas, m.hash)
+	for _, node := range nodes {
+		weight := m.Weight(node)
+		if weight == 0 {
+			weight = 1
+		}
+		ring.AddWeighted(node, weight)
+	}
+	return ring
+}
+
+// replicasAtHash returns the first rf distinct nodes at or after hash.
+func (m *Map) replicasAtHash(hash int, rf int) []string {
+	if len(m.keys) == 0 || rf <= 0 {
+		return nil
+	}
+	return m.replicasFrom(m.searchHash(hash), rf)
+}
+
+func first(nodes []string) string {
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodes[0]
+}
+
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	m := NewMap(50, nil)
+	oldNodes := []string{"a", "b", "c", "d"}
+	newNodes := []string{"a", "b", "c", "e"}
+	moves := m.Diff(oldNodes, newNodes, 2)
+	if len(moves) == 0 {
+		t.Fatalf("replacing a node should move some ranges")
+	}
+
+	before, after := m.build(oldNodes), m.build(newNodes)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		hash := crc32.ChecksumIEEE([]byte(key))
+		oldReplicas, newReplicas := before.GetReplicas(key, 2), after.GetReplicas(key, 2)
+
+		var move *RangeMove
+		for j := range moves {
+			if moves[j].Contains(hash) {
+				move = &moves[j]
+				break
+			}
+		}
+		changed := !reflect.DeepEqual(oldReplicas, newReplicas)
+		if changed != (move != nil) {
+			t.Fatalf("key %s changed=%v but in moved range=%v", key, changed, move != nil)
+		}
+		if move != nil && (!reflect.DeepEqual(move.OldReplicas, oldReplicas) ||
+			!reflect.DeepEqual(move.NewReplicas, newReplicas) || move.NewOwner != newReplicas[0]) {
+			t.Fatalf("range for key %s reports %v -> %v, want %v -> %v",
+				key, move.OldReplicas, move.NewReplicas, oldReplicas, newReplicas)
+		}
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	m := NewMap(50, nil)
+	oldNodes := []string{"a", "b", "c"}
+	newNodes := []string{"a", "b", "c", "d"}
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	give, take := m.Handoff("a", keys, oldNodes, newNodes, 1)
+	if len(give) == 0 || len(take) != 0 {
+		t.Fatalf("adding d should only take keys away from a, give=%d take=%d", len(give), len(take))
+	}
+	after := m.build(newNodes)
+	for _, key := range give {
+		if after.Get(key) != "d" {
+			t.Fatalf("key %s handed off to %s, expected d", key, after.Get(key))
+		}
+	}
+
+	give, take = m.Handoff("d", keys, oldNodes, newNodes, 1)
+	if len(give) != 0 || len(take) == 0 {
+		t.Fatalf("new node d should only take keys, give=%d take=%d", len(give), len(take))
+	}
+}
@@ -0,0 +1,14 @@
+package consistenthash
+
+import "github.com/cespare/xxhash/v2"
+
+// FormatVersion identifies how virtual nodes are laid out: the "<index><node>"
+// vnode key, weights as multiples of the vnode count and the name ordering of
+// colliding vnodes. Bump it whenever any of these change, so nodes running
+// different layouts refuse to talk to each other.
+const FormatVersion = 1
+
+// XXHash is a faster alternative to CRC32, the hash NewMap uses by default.
+func XXHash(data []byte) uint32 {
+	return uint32(xxhash.Sum64(data))
+}
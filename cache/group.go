@@ -4,7 +4,10 @@ import (
 	pb "distributed-cache/cache/pb"
 	"distributed-cache/cache/singleflight"
 	"fmt"
+	"math"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 type Getter interface {
@@ -26,6 +29,14 @@ type Group struct {
 	cache  *Cache
 	peers  PeerPicker
 	loader *singleflight.Group
+	beta   float64 // XFetch early expiration weight, 0 disables it
+	hedger *hedger // hedges slow peer loads, if enabled
+
+	replicationFactor int // copies of each key to keep across peers
+
+	setter     Setter            // write-through target, if any
+	deleter    Deleter           // write-through delete target, if any
+	writeQueue *WriteBehindQueue // write-behind queue, if any
 }
 
 var (
@@ -33,7 +44,47 @@ var (
 	mu     sync.RWMutex
 )
 
-func NewGroup(name string, cacheSize int64, getter Getter) *Group {
+// GroupOption configures optional behaviour of a Group.
+type GroupOption func(*Group)
+
+// WithTTL sets how long loaded values stay fresh. Zero (the default) means
+// values never expire.
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.cache.ttl = ttl
+	}
+}
+
+// WithStaleGrace keeps expired values for grace after their TTL. If a reload
+// fails inside that window, Get serves the stale value instead of the error.
+func WithStaleGrace(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.cache.staleGrace = grace
+	}
+}
+
+// WithReplicationFactor sets how many peers should hold each loaded key
+// (default 3). With fewer peers than n, every peer holds it and the group is
+// reported as under-replicated.
+func WithReplicationFactor(n int) GroupOption {
+	return func(g *Group) {
+		if n > 0 {
+			g.replicationFactor = n
+		}
+	}
+}
+
+// WithEarlyExpiration enables XFetch-style probabilistic early refresh.
+// A read refreshes the value in the background with a probability that grows
+// as expiry approaches, scaled by how long the last load took. beta = 1 is
+// the usual choice; larger values refresh earlier. Requires WithTTL.
+func WithEarlyExpiration(beta float64) GroupOption {
+	return func(g *Group) {
+		g.beta = beta
+	}
+}
+
+func NewGroup(name string, cacheSize int64, getter Getter, opts ...GroupOption) *Group {
 	if name == "" {
 		panic("Group name cannot be empty")
 	}
@@ -49,6 +100,11 @@ func NewGroup(name string, cacheSize int64, getter Getter) *Group {
 		getter: getter,
 		cache:  NewCache(cacheSize, nil),
 		loader: &singleflight.Group{},
+
+		replicationFactor: defaultReplicationFactor,
+	}
+	for _, opt := range opts {
+		opt(group)
 	}
 	groups[name] = group
 	return group
@@ -69,24 +125,74 @@ func GetGroup(name string) *Group {
 1. If the key is cached locally, return the cached value.
 2. If the key is cached in peer, get the value from the peer.
 3. If the key is not cached, use the getter function to retrieve the value.
+4. If loading fails but an expired value is still within its grace window,
+   return that value with Stale() set.
 */
 func (g *Group) Get(key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key cannot be empty")
 	}
 
-	if value, exists := g.cache.Get(key); exists {
-		return value, nil
+	if e, exists := g.cache.getEntry(key); exists {
+		if g.shouldRefreshEarly(e) {
+			earlyRefreshes.WithLabelValues(g.name).Inc()
+			go g.load(key)
+		}
+		return e.value, nil
+	}
+
+	value, err := g.load(key)
+	if err != nil {
+		if stale, exists := g.cache.GetStale(key); exists {
+			staleServes.WithLabelValues(g.name).Inc()
+			return stale, nil
+		}
 	}
+	return value, err
+}
 
-	return g.load(key)
+// shouldRefreshEarly implements the XFetch test:
+// now - delta * beta * ln(rand()) >= expiry.
+func (g *Group) shouldRefreshEarly(e entry) bool {
+	if g.beta <= 0 || e.expire.IsZero() || e.delta <= 0 {
+		return false
+	}
+	gap := -float64(e.delta) * g.beta * math.Log(1-rand.Float64())
+	return !nowFunc().Add(time.Duration(gap)).Before(e.expire)
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
+	// registeredGroups reads g.peers under mu.
+	mu.Lock()
+	defer mu.Unlock()
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
 	}
 	g.peers = peers
+	if rp, ok := peers.(ReplicaPicker); ok {
+		g.recordReplication(rp.PeerCount())
+	}
+}
+
+// ReplicationStatus returns the configured replication factor and how many
+// copies the current membership allows.
+func (g *Group) ReplicationStatus() (configured, effective int) {
+	configured = g.replicationFactor
+	effective = configured
+	if rp, ok := g.peers.(ReplicaPicker); ok && rp.PeerCount() < effective {
+		effective = rp.PeerCount()
+	}
+	return configured, effective
+}
+
+// recordReplication updates the under-replication gauge for a membership of
+// nodes peers.
+func (g *Group) recordReplication(nodes int) {
+	if nodes < g.replicationFactor {
+		underReplicated.WithLabelValues(g.name).Set(1)
+	} else {
+		underReplicated.WithLabelValues(g.name).Set(0)
+	}
 }
 
 func (g *Group) loadFn(key string) func() (interface{}, error) {
@@ -95,8 +201,25 @@ func (g *Group) loadFn(key string) func() (interface{}, error) {
 		if val, err := g.localLoad(key); err == nil {
 			return val, nil
 		}
-		// Peer Load
-		if g.peers != nil {
+		// Peer Load, failing over to the next replica
+		if fp, ok := g.peers.(FailoverPicker); ok {
+			peers := fp.PickPeers(key, g.replicationFactor)
+			for i := 0; i < len(peers); i++ {
+				if g.hedger != nil && i+1 < len(peers) {
+					val, hedged, err := g.hedgedLoad(peers[i], peers[i+1], key)
+					if err == nil {
+						return val, nil
+					}
+					if hedged {
+						i++
+					}
+					continue
+				}
+				if val, err := g.peerLoad(peers[i], key); err == nil {
+					return val, nil
+				}
+			}
+		} else if g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok {
 				if val, err := g.peerLoad(peer, key); err == nil {
 					return val, nil
@@ -121,6 +244,14 @@ func (g *Group) peerLoad(peer PeerClient, key string) (ByteView, error) {
 		Group: g.name,
 		Key:   key,
 	}
+	if ps, ok := peer.(PeerStreamer); ok {
+		r, err := ps.GetStream(req)
+		if err != nil {
+			return ByteView{}, err
+		}
+		defer r.Close()
+		return readChunks(r, g.cache.cacheSize)
+	}
 	res := &pb.Response{}
 	err := peer.Get(req, res)
 	if err != nil {
@@ -134,7 +265,12 @@ func (g *Group) localLoad(key string) (ByteView, error) {
 		return ByteView{}, fmt.Errorf("no getter function defined for group %s", g.name)
 	}
 
+	if lt, ok := g.peers.(localLoadTracker); ok {
+		defer lt.trackLocalLoad()()
+	}
+	start := time.Now()
 	data, err := g.getter.Get(key)
+	delta := time.Since(start)
 	if err != nil {
 		return ByteView{}, err
 	}
@@ -146,22 +282,22 @@ func (g *Group) localLoad(key string) (ByteView, error) {
 	value := ByteView{bytes: data}
 
 	// Local Add
-	g.cache.Add(key, value)
+	g.cache.addEntry(key, value, delta)
 
 	// Replicas Add
-	if httpPool, ok := g.peers.(*HTTPPool); ok {
+	g.replicate(key, data)
+	return value, nil
+}
+
+// replicate pushes data to the key's replicas in the background.
+func (g *Group) replicate(key string, data []byte) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
 		go func() {
-			peers := httpPool.peers.GetReplicas(key, defaultReplicationFactor)
 			req := &pb.SetRequest{Group: g.name, Key: key, Value: data}
 			var empty pb.EmptyResponse
-			for _, addr := range peers {
-				if addr == httpPool.self {
-					continue
-				}
-				getter := httpPool.httpGetters[addr]
-				_ = getter.Set(req, &empty)
+			for _, peer := range rp.ReplicaPeers(key, g.replicationFactor) {
+				_ = peer.Set(req, &empty)
 			}
 		}()
 	}
-	return value, nil
 }
@@ -1,10 +1,15 @@
 package cache
 
 import (
+	pb "distributed-cache/cache/pb"
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 var db = map[string]string{
@@ -65,3 +70,220 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("expect nil, but got %s", group.name)
 	}
 }
+
+func TestStaleIfError(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	fail := false
+	group := NewGroup("stale", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if fail {
+				return nil, fmt.Errorf("origin down")
+			}
+			return []byte("v1"), nil
+		}), WithTTL(time.Second), WithStaleGrace(time.Minute))
+
+	if view, err := group.Get("k"); err != nil || view.Stale() {
+		t.Fatalf("first load failed: %v", err)
+	}
+
+	fail = true
+	now = now.Add(2 * time.Second)
+	view, err := group.Get("k")
+	if err != nil || view.String() != "v1" || !view.Stale() {
+		t.Fatalf("expected stale v1, got %q stale=%v err=%v", view, view.Stale(), err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := group.Get("k"); err == nil {
+		t.Fatalf("expected error once the grace window has passed")
+	}
+}
+
+func TestShouldRefreshEarly(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	far := entry{expire: now.Add(time.Hour), delta: time.Nanosecond}
+	near := entry{expire: now.Add(time.Millisecond), delta: time.Second}
+
+	g := &Group{}
+	if g.shouldRefreshEarly(near) {
+		t.Fatalf("early expiration should be off without beta")
+	}
+	g.beta = 1
+	if g.shouldRefreshEarly(far) {
+		t.Fatalf("entry an hour from expiry should not refresh")
+	}
+	g.beta = 1e6
+	if !g.shouldRefreshEarly(near) {
+		t.Fatalf("entry about to expire with slow load should refresh")
+	}
+}
+
+// TestRegisterPeersConcurrently is meant for go test -race: groups register
+// with a pool while its membership changes.
+func TestRegisterPeersConcurrently(t *testing.T) {
+	pool := NewHTTPPool("http://a")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		group := NewGroup(fmt.Sprintf("register-race-%d", i), 2<<10, GetterFunc(
+			func(key string) ([]byte, error) { return []byte(key), nil }))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			group.RegisterPeers(pool)
+		}()
+		go func() {
+			defer wg.Done()
+			pool.Set("http://a", "http://b")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReplicationFactorClamped(t *testing.T) {
+	group := NewGroup("small-cluster", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) { return []byte(key), nil }),
+		WithReplicationFactor(3))
+	pool := NewHTTPPool("http://a")
+	pool.Set("http://a", "http://b")
+	group.RegisterPeers(pool)
+
+	if configured, effective := group.ReplicationStatus(); configured != 3 || effective != 2 {
+		t.Fatalf("expected 3 configured / 2 effective, got %d / %d", configured, effective)
+	}
+	if v := testutil.ToFloat64(underReplicated.WithLabelValues("small-cluster")); v != 1 {
+		t.Fatalf("group should be reported under-replicated")
+	}
+	if replicas := pool.replicas("key", 3); len(replicas) != 2 {
+		t.Fatalf("expected both nodes as replicas, got %v", replicas)
+	}
+
+	pool.Set("http://a", "http://b", "http://c")
+	if v := testutil.ToFloat64(underReplicated.WithLabelValues("small-cluster")); v != 0 {
+		t.Fatalf("group should no longer be under-replicated")
+	}
+}
+
+type fakePeer struct {
+	value string
+	err   error
+	calls int
+}
+
+func (f *fakePeer) Get(in *pb.GetRequest, out *pb.Response) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	out.Value = []byte(f.value)
+	return nil
+}
+
+type fakeFailoverPicker []PeerClient
+
+func (f fakeFailoverPicker) PickPeer(key string) (PeerClient, bool)   { return f[0], true }
+func (f fakeFailoverPicker) PickPeers(key string, n int) []PeerClient { return f }
+
+func TestPeerLoadFailover(t *testing.T) {
+	down := &fakePeer{err: fmt.Errorf("connection refused")}
+	up := &fakePeer{value: "from-replica"}
+	group := NewGroup("failover", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("origin down")
+	}))
+	group.RegisterPeers(fakeFailoverPicker{down, up})
+
+	view, err := group.Get("k")
+	if err != nil || view.String() != "from-replica" {
+		t.Fatalf("expected the second replica's value, got %q, %v", view, err)
+	}
+	if down.calls != 1 || up.calls != 1 {
+		t.Fatalf("expected one call per replica, got %d and %d", down.calls, up.calls)
+	}
+}
+
+// factorPicker records the replication factor it is asked for.
+type factorPicker struct {
+	fakeFailoverPicker
+	n int
+}
+
+func (f *factorPicker) PickPeers(key string, n int) []PeerClient {
+	f.n = n
+	return f.fakeFailoverPicker
+}
+
+func TestFailoverUsesReplicationFactor(t *testing.T) {
+	group := NewGroup("failover-factor", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("origin down")
+	}), WithReplicationFactor(5))
+	picker := &factorPicker{fakeFailoverPicker: fakeFailoverPicker{&fakePeer{value: "v"}}}
+	group.RegisterPeers(picker)
+
+	if _, err := group.Get("k"); err != nil {
+		t.Fatal(err)
+	}
+	if picker.n != 5 {
+		t.Fatalf("failover should try the group's 5 replicas, asked for %d", picker.n)
+	}
+}
+
+type slowPeer struct {
+	value string
+	delay time.Duration
+}
+
+func (s slowPeer) Get(in *pb.GetRequest, out *pb.Response) error {
+	time.Sleep(s.delay)
+	out.Value = []byte(s.value)
+	return nil
+}
+
+func TestHedgedPeerLoad(t *testing.T) {
+	peers := fakeFailoverPicker{slowPeer{"slow", 200 * time.Millisecond}, slowPeer{"fast", 0}}
+	origin := GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("origin down")
+	})
+
+	group := NewGroup("hedged", 2<<10, origin, WithHedging(10*time.Millisecond, 1))
+	group.RegisterPeers(peers)
+	start := time.Now()
+	view, err := group.Get("k")
+	if err != nil || view.String() != "fast" {
+		t.Fatalf("expected the hedge to win, got %q, %v", view, err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("hedged load took %v", elapsed)
+	}
+	if won := testutil.ToFloat64(hedges.WithLabelValues("hedged", "won")); won != 1 {
+		t.Fatalf("expected 1 won hedge, got %v", won)
+	}
+
+	// A budget of 10% has no hedge to spend on the first load.
+	group = NewGroup("hedged-budget", 2<<10, origin, WithHedging(10*time.Millisecond, 0.1))
+	group.RegisterPeers(peers)
+	view, err = group.Get("k")
+	if err != nil || view.String() != "slow" {
+		t.Fatalf("expected the primary's value, got %q, %v", view, err)
+	}
+	if over := testutil.ToFloat64(hedges.WithLabelValues("hedged-budget", "over_budget")); over != 1 {
+		t.Fatalf("expected 1 over-budget hedge, got %v", over)
+	}
+}
+
+func TestHedgeDelayTracksP95(t *testing.T) {
+	h := &hedger{}
+	if h.wait() != defaultHedgeWait {
+		t.Fatalf("expected the default wait before any samples, got %v", h.wait())
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if w := h.wait(); w < 90*time.Millisecond || w > 100*time.Millisecond {
+		t.Fatalf("expected a wait near the P95 of 1..100ms, got %v", w)
+	}
+}
@@ -0,0 +1,410 @@
+package cache
+
+import (
+	"context"
+	"distributed-cache/cache/consistenthash"
+	pb "distributed-cache/cache/pb"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRPCTimeout = 2 * time.Second
+	// maxGRPCMessage raises gRPC's 4MiB message limit, as values are sent
+	// in one message.
+	maxGRPCMessage = maxPayload
+	// ringFormatMetadata carries the sender's ring format on peer RPCs.
+	ringFormatMetadata = "x-dcache-ring-format"
+)
+
+// GRPCPool is a PeerPicker that talks to peers over the GroupCache gRPC
+// service instead of HTTP. Peers are gRPC targets such as "10.0.0.2:9000".
+// One connection per peer is kept and reused across calls and membership
+// updates. Values travel in one message of up to 64MiB; streaming large
+// values in chunks is only done by HTTPPool.
+type GRPCPool struct {
+	self     string
+	mu       sync.Mutex // serializes membership updates
+	state    atomic.Pointer[grpcState]
+	dialOpts []grpc.DialOption
+	timeout  time.Duration
+	cooldown time.Duration
+	tls      *PeerTLS // nil for plaintext
+	signer   *Signer  // nil leaves calls unsigned and unchecked
+	hashName string
+	hash     consistenthash.Hash
+	vnodes   int
+	format   string
+}
+
+type grpcState struct {
+	peers   *consistenthash.Map
+	clients map[string]*grpcGetter
+}
+
+// GRPCPoolOption configures optional behaviour of a GRPCPool.
+type GRPCPoolOption func(*GRPCPool)
+
+// WithDialOptions adds options used when connecting to peers, e.g.
+// transport credentials or a custom dialer.
+func WithDialOptions(opts ...grpc.DialOption) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.dialOpts = append(p.dialOpts, opts...)
+	}
+}
+
+// WithSuspectCooldown sets how long a peer whose load failed is skipped by
+// later picks (default 5s).
+func WithSuspectCooldown(d time.Duration) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.cooldown = d
+	}
+}
+
+// WithGRPCHash sets the key hash of the pool's consistent hash ring, like
+// WithHash does for HTTPPool (default xxhash).
+func WithGRPCHash(name string, fn consistenthash.Hash) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.hashName = name
+		p.hash = fn
+	}
+}
+
+// WithGRPCVirtualNodes sets the number of virtual nodes per peer on the
+// pool's consistent hash ring (default 100).
+func WithGRPCVirtualNodes(n int) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		if n > 0 {
+			p.vnodes = n
+		}
+	}
+}
+
+// WithRPCTimeout sets the deadline of each peer call (default 2s).
+func WithRPCTimeout(d time.Duration) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.timeout = d
+	}
+}
+
+func NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	pool := &GRPCPool{
+		self:     self,
+		timeout:  defaultRPCTimeout,
+		cooldown: defaultSuspectCooldown,
+		hashName: defaultHashName,
+		hash:     defaultHash,
+		vnodes:   defaultReplicas,
+		dialOpts: []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(
+				grpc.MaxCallRecvMsgSize(maxGRPCMessage),
+				grpc.MaxCallSendMsgSize(maxGRPCMessage),
+			),
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:                30 * time.Second,
+				Timeout:             10 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		},
+	}
+	for _, opt := range opts {
+		opt(pool)
+	}
+	pool.format = ringFormat(pool.hashName, pool.vnodes)
+	pool.state.Store(&grpcState{
+		peers:   consistenthash.NewMap(pool.vnodes, pool.hash),
+		clients: make(map[string]*grpcGetter),
+	})
+	return pool
+}
+
+// NewServer returns a gRPC server with keepalive settings matching the pool's
+// clients and the GroupCache service registered. Serve it on the address this
+// node is known by to its peers. With WithGRPCTLS it serves over TLS, and
+// with WithGRPCSigner it only accepts signed calls.
+func (p *GRPCPool) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	defaults := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxGRPCMessage),
+		grpc.MaxSendMsgSize(maxGRPCMessage),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    time.Minute,
+			Timeout: 10 * time.Second,
+		}),
+	}
+	if p.tls != nil {
+		defaults = append(defaults, grpc.Creds(credentials.NewTLS(p.tls.ServerConfig())))
+	}
+	if p.signer != nil {
+		defaults = append(defaults, grpc.ChainUnaryInterceptor(p.signer.unaryServerInterceptor))
+	}
+	s := grpc.NewServer(append(defaults, opts...)...)
+	pb.RegisterGroupCacheServer(s, &grpcServer{format: p.format})
+	return s
+}
+
+// Set updates the pool's membership. Connections to remaining peers are kept;
+// connections to removed peers are closed once the calls still using them
+// finish.
+func (p *GRPCPool) Set(peers ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.state.Load()
+
+	next := &grpcState{
+		peers:   old.peers.Clone(),
+		clients: make(map[string]*grpcGetter, len(peers)),
+	}
+	next.peers.SetNodes(peers...)
+	var errs []error
+	for _, addr := range peers {
+		if client, exists := old.clients[addr]; exists {
+			next.clients[addr] = client
+			continue
+		}
+		conn, err := grpc.NewClient(addr, p.dialOpts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", addr, err))
+			continue
+		}
+		next.clients[addr] = &grpcGetter{
+			conn:     conn,
+			client:   pb.NewGroupCacheClient(conn),
+			timeout:  p.timeout,
+			cooldown: p.cooldown,
+			format:   p.format,
+		}
+	}
+	p.state.Store(next)
+
+	for addr, client := range old.clients {
+		if _, kept := next.clients[addr]; !kept {
+			client.retire()
+		}
+	}
+	for _, g := range registeredGroups(p) {
+		g.recordReplication(len(next.clients))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every peer connection.
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, client := range p.state.Load().clients {
+		errs = append(errs, client.close())
+	}
+	return errors.Join(errs...)
+}
+
+func (p *GRPCPool) PickPeer(key string) (PeerClient, bool) {
+	if peers := p.PickPeers(key, defaultReplicationFactor); len(peers) > 0 {
+		return peers[0], true
+	}
+	return nil, false
+}
+
+// PickPeers returns the key's first n replicas other than this node, in ring
+// order, skipping suspect peers.
+func (p *GRPCPool) PickPeers(key string, n int) []PeerClient {
+	state := p.state.Load()
+	var peers []PeerClient
+	for _, addr := range state.peers.GetReplicas(key, n) {
+		if client, ok := state.clients[addr]; ok && addr != p.self && !client.Suspect() {
+			peers = append(peers, client)
+		}
+	}
+	return peers
+}
+
+func (p *GRPCPool) ReplicaPeers(key string, n int) []PeerWriter {
+	state := p.state.Load()
+	var peers []PeerWriter
+	for _, addr := range state.peers.GetReplicas(key, n) {
+		if client, ok := state.clients[addr]; ok && addr != p.self {
+			peers = append(peers, client)
+		}
+	}
+	return peers
+}
+
+func (p *GRPCPool) PeerCount() int {
+	return len(p.state.Load().peers.Nodes())
+}
+
+// checkDelete refuses deletes: the GroupCache service has no Delete, so
+// peers would keep serving the deleted value.
+func (p *GRPCPool) checkDelete() error {
+	return errors.New("gRPC transport cannot delete keys from peers; use HTTPPool for groups that delete")
+}
+
+// grpcGetter is a PeerClient for one peer's GroupCache service.
+type grpcGetter struct {
+	conn     *grpc.ClientConn
+	client   pb.GroupCacheClient
+	timeout  time.Duration
+	cooldown time.Duration
+	format   string
+	suspicion
+
+	mu      sync.Mutex
+	calls   int  // calls using conn
+	retired bool // removed from the pool; close conn once calls drain
+	closed  bool
+}
+
+var errPeerRemoved = status.Error(codes.Unavailable, "peer removed from the pool")
+
+// acquire registers a call on conn, failing if conn is already closed.
+func (g *grpcGetter) acquire() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return errPeerRemoved
+	}
+	g.calls++
+	return nil
+}
+
+func (g *grpcGetter) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls--
+	if g.retired && g.calls == 0 {
+		g.closeLocked()
+	}
+}
+
+// retire closes conn once the calls in progress finish.
+func (g *grpcGetter) retire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.retired = true
+	if g.calls == 0 {
+		g.closeLocked()
+	}
+}
+
+// close closes conn at once.
+func (g *grpcGetter) close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closeLocked()
+}
+
+func (g *grpcGetter) closeLocked() error {
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+	return g.conn.Close()
+}
+
+func (g *grpcGetter) context() (context.Context, context.CancelFunc) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ringFormatMetadata, g.format)
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+func (g *grpcGetter) Get(in *pb.GetRequest, out *pb.Response) error {
+	if err := g.acquire(); err != nil {
+		return err
+	}
+	defer g.release()
+	ctx, cancel := g.context()
+	defer cancel()
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		if code := status.Code(err); code == codes.Unavailable || code == codes.DeadlineExceeded {
+			g.suspect(g.cooldown)
+		}
+		return err
+	}
+	out.Value = res.GetValue()
+	return nil
+}
+
+func (g *grpcGetter) Set(in *pb.SetRequest, out *pb.EmptyResponse) error {
+	if err := g.acquire(); err != nil {
+		return err
+	}
+	defer g.release()
+	ctx, cancel := g.context()
+	defer cancel()
+	_, err := g.client.Set(ctx, in)
+	return err
+}
+
+// Delete is not part of the GroupCache service. Group.Delete refuses to run
+// on a GRPCPool, see checkDelete, so this is never reached from a Group.
+func (g *grpcGetter) Delete(in *pb.GetRequest, out *pb.EmptyResponse) error {
+	return status.Error(codes.Unimplemented, "GroupCache service has no Delete")
+}
+
+// grpcServer serves this node's groups to gRPC peers.
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	format string
+}
+
+// checkFormat refuses calls from peers with a different ring format. Calls
+// without one are served, as on the HTTP transport.
+func (s *grpcServer) checkFormat(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get(ringFormatMetadata); len(got) > 0 && got[0] != s.format {
+		return status.Errorf(codes.FailedPrecondition, "ring format mismatch: peer %q, local %q", got[0], s.format)
+	}
+	return nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, in *pb.GetRequest) (*pb.Response, error) {
+	if err := s.checkFormat(ctx); err != nil {
+		return nil, err
+	}
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "group not found: %s", in.GetGroup())
+	}
+	bv, err := group.Get(in.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{Value: bv.Bytes()}, nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.EmptyResponse, error) {
+	if err := s.checkFormat(ctx); err != nil {
+		return nil, err
+	}
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "group not found: %s", in.GetGroup())
+	}
+	if int64(len(in.GetValue())) > group.cache.cacheSize {
+		return nil, status.Errorf(codes.InvalidArgument, "data size exceeds cache size")
+	}
+	group.cache.Add(in.GetKey(), ByteView{bytes: in.GetValue()})
+	return &pb.EmptyResponse{}, nil
+}
+
+// Compile time assertion
+var _ PeerClient = (*grpcGetter)(nil)
+var _ PeerWriter = (*grpcGetter)(nil)
+var _ ReplicaPicker = (*GRPCPool)(nil)
+var _ FailoverPicker = (*GRPCPool)(nil)
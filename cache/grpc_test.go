@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"context"
+	pb "distributed-cache/cache/pb"
+	"errors"
+	"hash/crc32"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPCPools serves n pools on in-process listeners and joins them.
+func startGRPCPools(t *testing.T, n int, opts ...GRPCPoolOption) []*GRPCPool {
+	t.Helper()
+	listeners := make(map[string]*bufconn.Listener, n)
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = "passthrough:///node" + string(rune('a'+i))
+		listeners[addrs[i]] = bufconn.Listen(1 << 20)
+	}
+	dialer := grpc.WithContextDialer(func(ctx context.Context, target string) (net.Conn, error) {
+		return listeners["passthrough:///"+target].DialContext(ctx)
+	})
+
+	pools := make([]*GRPCPool, n)
+	for i, addr := range addrs {
+		pools[i] = NewGRPCPool(addr, append([]GRPCPoolOption{WithDialOptions(dialer)}, opts...)...)
+		srv := pools[i].NewServer()
+		go srv.Serve(listeners[addr])
+		t.Cleanup(srv.Stop)
+		t.Cleanup(func() { pools[i].Close() })
+	}
+	for _, pool := range pools {
+		if err := pool.Set(addrs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pools
+}
+
+func TestGRPCLargeValue(t *testing.T) {
+	NewGroup("grpc-large", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("unused")
+	}))
+	pools := startGRPCPools(t, 2)
+	client := pools[0].state.Load().clients[pools[1].self]
+	// Past gRPC's default 4MiB message limit, so it reaches the server only
+	// with the raised limit, and past the group's size, so it is refused
+	// there.
+	req := &pb.SetRequest{Group: "grpc-large", Key: "k", Value: make([]byte, 8<<20)}
+	if err := client.Set(req, &pb.EmptyResponse{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("a value larger than the group should be refused by the server, got %v", err)
+	}
+	req.Value = []byte("small")
+	if err := client.Set(req, &pb.EmptyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := GetGroup("grpc-large").cache.Get("k"); !ok || view.String() != "small" {
+		t.Fatal("a value that fits should be stored")
+	}
+}
+
+func TestGRPCPeerGetSet(t *testing.T) {
+	pools := startGRPCPools(t, 3)
+	var loads atomic.Int32
+	group := NewGroup("grpc-peers", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("v-" + key), nil
+	}))
+
+	var (
+		peer PeerClient
+		ok   bool
+		key  string
+	)
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		if peer, ok = pools[0].PickPeer(k); ok {
+			key = k
+			break
+		}
+	}
+	if !ok {
+		t.Fatal("expected some key to be owned by a remote peer")
+	}
+
+	var res pb.Response
+	if err := peer.Get(&pb.GetRequest{Group: "grpc-peers", Key: key}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "v-"+key || loads.Load() != 1 {
+		t.Fatalf("got %q after %d loads", res.Value, loads.Load())
+	}
+
+	writer := peer.(PeerWriter)
+	if err := writer.Set(&pb.SetRequest{Group: "grpc-peers", Key: "pushed", Value: []byte("x")}, &pb.EmptyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := group.cache.Get("pushed"); !ok || v.String() != "x" {
+		t.Fatalf("replicated value missing, got %q %v", v.String(), ok)
+	}
+
+	err := peer.Get(&pb.GetRequest{Group: "no-such-group", Key: key}, &res)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestGRPCPoolReusesConnections(t *testing.T) {
+	pools := startGRPCPools(t, 3)
+	before := pools[0].state.Load().clients
+	addrs := pools[0].state.Load().peers.Nodes()
+
+	if err := pools[0].Set(addrs[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	after := pools[0].state.Load().clients
+	if len(after) != 2 {
+		t.Fatalf("expected 2 clients, got %d", len(after))
+	}
+	for addr, client := range after {
+		if before[addr] != client {
+			t.Fatalf("connection to %s was not reused", addr)
+		}
+	}
+	if pools[0].PeerCount() != 2 {
+		t.Fatalf("expected 2 peers, got %d", pools[0].PeerCount())
+	}
+}
+
+func TestGRPCRingFormatMismatch(t *testing.T) {
+	pools := startGRPCPools(t, 2)
+	NewGroup("grpc-format", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	client := pools[0].state.Load().clients[pools[1].self]
+	client.format = "ring/v0/other/1"
+
+	err := client.Get(&pb.GetRequest{Group: "grpc-format", Key: "k"}, &pb.Response{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestGRPCRingOptions(t *testing.T) {
+	pools := startGRPCPools(t, 2, WithGRPCHash("crc32", crc32.ChecksumIEEE), WithGRPCVirtualNodes(10))
+	if want := ringFormat("crc32", 10); pools[0].format != want {
+		t.Fatalf("format = %q, want %q", pools[0].format, want)
+	}
+	if NewGRPCPool("x").format != NewHTTPPool("http://x").RingFormat() {
+		t.Fatal("default gRPC and HTTP pools should share a ring format")
+	}
+	if got := pools[0].state.Load().peers.VirtualNodes(pools[1].self); got != 10 {
+		t.Fatalf("expected 10 virtual nodes per peer, got %d", got)
+	}
+}
+
+func TestGRPCDeleteRefused(t *testing.T) {
+	pools := startGRPCPools(t, 2)
+	store := &memStore{data: map[string]string{"Tom": "630"}}
+	group := NewGroup("grpc-delete", 2<<10, store, WithWriteThrough(store))
+	group.RegisterPeers(pools[0])
+
+	if err := group.Delete("Tom"); err == nil {
+		t.Fatal("delete over gRPC should fail rather than leave peers stale")
+	}
+	if _, err := store.Get("Tom"); err != nil {
+		t.Fatal("a refused delete should not reach the origin")
+	}
+}
+
+func TestGRPCRemovedPeerDrains(t *testing.T) {
+	pools := startGRPCPools(t, 3)
+	addrs := pools[0].state.Load().peers.Nodes()
+	removed := pools[0].state.Load().clients[addrs[2]]
+
+	// A call still using the connection keeps it open past removal.
+	if err := removed.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pools[0].Set(addrs[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if removed.conn.GetState() == connectivity.Shutdown {
+		t.Fatal("connection closed under an in-flight call")
+	}
+	removed.release()
+	if removed.conn.GetState() != connectivity.Shutdown {
+		t.Fatal("connection should close once its calls finish")
+	}
+	if err := removed.Get(&pb.GetRequest{Group: "g", Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatal("calls on a closed peer should fail")
+	}
+}
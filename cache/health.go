@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HealthConfig sets how peers are probed on their _health route.
+type HealthConfig struct {
+	Interval           time.Duration // between probes of a peer
+	Timeout            time.Duration // for one probe
+	HealthyThreshold   int           // successes in a row to mark a peer up
+	UnhealthyThreshold int           // failures in a row to mark a peer down
+
+	// OnChange, if set, is called from the prober whenever a peer goes up
+	// or down.
+	OnChange func(HealthEvent)
+}
+
+// DefaultHealthConfig probes every second and takes a peer out of routing
+// after 3 failed probes, back in after 2 good ones.
+var DefaultHealthConfig = HealthConfig{
+	Interval:           time.Second,
+	Timeout:            500 * time.Millisecond,
+	HealthyThreshold:   2,
+	UnhealthyThreshold: 3,
+}
+
+// HealthEvent reports a peer's health transition.
+type HealthEvent struct {
+	Peer    string
+	Healthy bool
+	At      time.Time
+	Err     error // last probe error when going down
+}
+
+// WithHealthCheck probes every peer in the background and leaves peers that
+// fail the probes out of routing until they pass again. Zero fields of cfg
+// take the defaults in DefaultHealthConfig. Call Close to stop the probers.
+func WithHealthCheck(cfg HealthConfig) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		d := DefaultHealthConfig
+		if cfg.Interval <= 0 {
+			cfg.Interval = d.Interval
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = d.Timeout
+		}
+		if cfg.HealthyThreshold <= 0 {
+			cfg.HealthyThreshold = d.HealthyThreshold
+		}
+		if cfg.UnhealthyThreshold <= 0 {
+			cfg.UnhealthyThreshold = d.UnhealthyThreshold
+		}
+		p.health = &cfg
+	}
+}
+
+// Healthy reports whether the peer passes its health checks. Peers are
+// healthy until probes say otherwise.
+func (h *HTTPGetter) Healthy() bool {
+	return !h.unhealthy.Load()
+}
+
+// startHealthCheck probes h until stopHealthCheck is called.
+func (p *HTTPPool) startHealthCheck(h *HTTPGetter) {
+	h.stopHealth = make(chan struct{})
+	peerHealthy.WithLabelValues(h.addr).Set(1)
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(p.health.Interval)
+		defer ticker.Stop()
+		var successes, failures int
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			err := h.probe(p.health.Timeout)
+			if err == nil {
+				successes, failures = successes+1, 0
+				if !h.Healthy() && successes >= p.health.HealthyThreshold {
+					p.setHealth(h, true, nil)
+				}
+				continue
+			}
+			successes, failures = 0, failures+1
+			if h.Healthy() && failures >= p.health.UnhealthyThreshold {
+				p.setHealth(h, false, err)
+			}
+		}
+	}(h.stopHealth)
+}
+
+func (p *HTTPPool) stopHealthCheck(h *HTTPGetter) {
+	if h.stopHealth != nil {
+		close(h.stopHealth)
+		peerHealthy.DeleteLabelValues(h.addr)
+	}
+}
+
+// setHealth records a health transition and reports it.
+func (p *HTTPPool) setHealth(h *HTTPGetter, healthy bool, err error) {
+	h.unhealthy.Store(!healthy)
+	state := "down"
+	if healthy {
+		state = "up"
+		peerHealthy.WithLabelValues(h.addr).Set(1)
+	} else {
+		peerHealthy.WithLabelValues(h.addr).Set(0)
+	}
+	peerHealthTransitions.WithLabelValues(h.addr, state).Inc()
+	p.Log("peer %s is %s (%v)", h.addr, state, err)
+	if p.health.OnChange != nil {
+		p.health.OnChange(HealthEvent{Peer: h.addr, Healthy: healthy, At: time.Now(), Err: err})
+	}
+}
+
+// probe asks the peer for its health. It bypasses the peer's circuit
+// breaker, so a peer with an open circuit is still checked.
+func (h *HTTPGetter) probe(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := h.newRequest(ctx, http.MethodGet, h.baseURL+healthPath, nil, "")
+	if err != nil {
+		return err
+	}
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// Close stops the health checks of every peer and closes idle peer
+// connections.
+func (p *HTTPPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, getter := range p.state.Load().httpGetters {
+		p.stopHealthCheck(getter)
+		getter.stopHealth = nil
+	}
+	p.client.CloseIdleConnections()
+}
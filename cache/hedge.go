@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeSamples     = 256 // recent peer load latencies kept for the percentile
+	hedgeRecompute   = 32  // samples between percentile recomputations
+	hedgeMaxTokens   = 10  // largest burst of hedges the budget allows
+	hedgePercentile  = 0.95
+	defaultHedgeWait = 10 * time.Millisecond // until enough samples are seen
+)
+
+// WithHedging sends a peer load to the key's next replica too when the first
+// has not answered within delay, and uses whichever answers first. A delay
+// of 0 uses the observed P95 latency of peer loads. budget caps hedges as a
+// fraction of peer loads, e.g. 0.05 for at most 5% extra requests; it
+// starts empty and fills as peer loads are made.
+func WithHedging(delay time.Duration, budget float64) GroupOption {
+	return func(g *Group) {
+		g.hedger = &hedger{delay: delay, budget: budget}
+	}
+}
+
+// hedger decides when to hedge and keeps the budget and latency samples.
+type hedger struct {
+	delay  time.Duration
+	budget float64
+
+	mu      sync.Mutex
+	tokens  float64
+	samples []time.Duration
+	next    int // index of the oldest sample once full
+	seen    int // samples since the percentile was computed
+	p95     time.Duration
+}
+
+// wait returns how long to wait for the primary before hedging.
+func (h *hedger) wait() time.Duration {
+	if h.delay > 0 {
+		return h.delay
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.p95 > 0 {
+		return h.p95
+	}
+	return defaultHedgeWait
+}
+
+// earn adds the budget share of one peer load.
+func (h *hedger) earn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.budget
+	if h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+}
+
+// spend takes one hedge from the budget, if there is one left.
+func (h *hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+// observe records the latency of a successful peer load.
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	h.seen++
+	if h.seen >= hedgeRecompute {
+		h.seen = 0
+		sorted := append([]time.Duration(nil), h.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		h.p95 = sorted[int(float64(len(sorted)-1)*hedgePercentile)]
+	}
+}
+
+type hedgeResult struct {
+	value  ByteView
+	err    error
+	hedged bool // came from the hedge, not the primary
+}
+
+// hedgedLoad loads key from primary, and from backup as well if primary is
+// slower than the hedge delay and the budget allows. It returns the first
+// success, or an error once every request sent has failed; hedged reports
+// whether backup was tried.
+func (g *Group) hedgedLoad(primary, backup PeerClient, key string) (value ByteView, hedged bool, err error) {
+	h := g.hedger
+	h.earn()
+	results := make(chan hedgeResult, 2)
+	send := func(peer PeerClient, hedged bool) {
+		start := time.Now()
+		value, err := g.peerLoad(peer, key)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{value, err, hedged}
+	}
+	go send(primary, false)
+
+	timer := time.NewTimer(h.wait())
+	defer timer.Stop()
+	pending := 1
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !h.spend() {
+				hedges.WithLabelValues(g.name, "over_budget").Inc()
+				continue
+			}
+			hedged = true
+			pending++
+			go send(backup, true)
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				err = res.err
+				if !hedged {
+					// The primary failed before the hedge was sent; leave
+					// the backup to the caller's failover.
+					return ByteView{}, false, err
+				}
+				continue
+			}
+			if hedged {
+				outcome := "lost"
+				if res.hedged {
+					outcome = "won"
+				}
+				hedges.WithLabelValues(g.name, outcome).Inc()
+			}
+			return res.value, hedged, nil
+		}
+	}
+	hedges.WithLabelValues(g.name, "failed").Inc()
+	return ByteView{}, true, err
+}
@@ -2,15 +2,22 @@ package cache
 
 import (
 	"bytes"
+	"context"
+	"distributed-cache/cache/breaker"
 	"distributed-cache/cache/consistenthash"
 	pb "distributed-cache/cache/pb"
+	"distributed-cache/cache/placement"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -19,21 +26,414 @@ const (
 	defaultPath              = "/dcache/"
 	defaultReplicas          = 100 // Number of vnodes
 	defaultReplicationFactor = 3   // number of replicated data
+	defaultHashName          = "xxhash"
+	defaultAttemptTimeout    = time.Second     // per peer load, before failing over
+	defaultSuspectCooldown   = 5 * time.Second // how long a failed peer is skipped
+
+	// ringFormatHeader carries the sender's ring format on peer requests.
+	ringFormatHeader = "X-Dcache-Ring-Format"
 )
 
 type HTTPPool struct {
-	self        string
-	basePath    string
-	mu          sync.Mutex
-	peers       *consistenthash.Map
+	self     string
+	basePath string
+	mu       sync.Mutex // serializes membership updates
+	state    atomic.Pointer[poolState]
+
+	placement    placement.Placement // initial placement, before any peers
+	hashName     string
+	hash         consistenthash.Hash
+	vnodes       int
+	format       string // ring format peers must share, see RingFormat
+	zoneAware    bool
+	boundedLoad  bool
+	loadEpsilon  float64
+	selfInFlight atomic.Int64 // local loads in progress, counted like HTTPGetter.InFlight
+
+	attemptTimeout  time.Duration
+	suspectCooldown time.Duration
+	breakerConfig   breaker.Config
+	health          *HealthConfig // nil disables health checks
+	tls             *PeerTLS      // nil for plain HTTP
+	signer          *Signer       // nil leaves requests unsigned
+
+	compressThreshold int      // smallest payload worth compressing
+	codings           []string // preferred content codings, nil disables compression
+
+	clientConfig HTTPClientConfig
+	wrapRT       func(http.RoundTripper) http.RoundTripper
+	client       *http.Client // shared by all peer getters
+}
+
+// poolState is an immutable snapshot of the pool's membership. Updates build
+// a new snapshot and publish it atomically, so reads never take a lock.
+type poolState struct {
+	version     uint64
+	peers       placement.Placement
 	httpGetters map[string]*HTTPGetter
 }
 
+// ring returns the consistent hash ring under the placement, if the
+// placement is (or wraps) one.
+func (s *poolState) ring() (*consistenthash.Map, bool) {
+	pl := s.peers
+	if topo, ok := pl.(*placement.TopologyAware); ok {
+		pl = topo.Base()
+	}
+	ring, ok := pl.(placement.Ring)
+	return ring.Map, ok
+}
+
+// defaultHash is the key hash of the default ring. It is faster than the
+// CRC32 that consistenthash.NewMap falls back to; its name is part of the
+// ring format, so nodes still hashing with CRC32 are refused if they send
+// their format. Changing it moves every key; see the README on upgrading.
+var defaultHash consistenthash.Hash = consistenthash.XXHash
+
+// HTTPPoolOption configures optional behaviour of an HTTPPool.
+type HTTPPoolOption func(*HTTPPool)
+
+// WithPlacement replaces the default consistent hash ring with another
+// placement algorithm, e.g. placement.NewRendezvous().
+func WithPlacement(pl placement.Placement) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.placement = pl
+	}
+}
+
+// WithHash sets the key hash of the default consistent hash ring (default
+// xxhash), e.g. WithHash("crc32", crc32.ChecksumIEEE). name identifies the
+// hash in the ring format, so every node must use the same name for the same
+// function.
+func WithHash(name string, fn consistenthash.Hash) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.hashName = name
+		p.hash = fn
+	}
+}
+
+// WithVirtualNodes sets the number of virtual nodes per peer on the default
+// consistent hash ring (default 100).
+func WithVirtualNodes(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		if n > 0 {
+			p.vnodes = n
+		}
+	}
+}
+
+// WithZoneAwareReplicas spreads each key's replicas across the zones, racks
+// and hosts given in SetPeers, on top of whichever placement is in use.
+func WithZoneAwareReplicas() HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.zoneAware = true
+	}
+}
+
+// WithBoundedLoad makes PickPeer use consistent hashing with bounded loads:
+// a peer with more than (1+epsilon) times the average in-flight loads is
+// skipped in favour of the next one on the ring. Loads are counted as this
+// node sees them: its calls to each peer and the loads it runs itself. It
+// only applies to the default consistent hash ring.
+func WithBoundedLoad(epsilon float64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.boundedLoad = true
+		p.loadEpsilon = epsilon
+	}
+}
+
+// WithFailover sets how long a peer load may take before the next replica is
+// tried, and how long a peer that failed is skipped by later picks.
+func WithFailover(attemptTimeout, cooldown time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		if attemptTimeout > 0 {
+			p.attemptTimeout = attemptTimeout
+		}
+		if cooldown > 0 {
+			p.suspectCooldown = cooldown
+		}
+	}
+}
+
+// DefaultPeerBreaker opens a peer's circuit after 5 straight failures, or
+// when half of at least 20 calls in 10s fail or take over 500ms.
+var DefaultPeerBreaker = breaker.Config{
+	ConsecutiveFailures: 5,
+	Cooldown:            5 * time.Second,
+	Window:              10 * time.Second,
+	MinCalls:            20,
+	ErrorRate:           0.5,
+	SlowCall:            500 * time.Millisecond,
+	SlowRate:            0.5,
+}
+
+// WithPeerBreaker sets when the circuit breaker of each peer opens. Only
+// loads count towards it, 5xx replies included. While a peer's circuit is
+// open, picks skip it and loads and replica writes to it fail at once; admin
+// calls still go through.
+func WithPeerBreaker(cfg breaker.Config) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.breakerConfig = cfg
+	}
+}
+
+// HTTPClientConfig tunes the HTTP client used for peer calls. Zero fields
+// take the defaults in DefaultHTTPClientConfig.
+type HTTPClientConfig struct {
+	DialTimeout           time.Duration // connecting to a peer
+	ResponseHeaderTimeout time.Duration // waiting for a peer to start answering
+	Timeout               time.Duration // whole request, body included
+	KeepAlive             time.Duration // TCP keepalive period
+	IdleConnTimeout       time.Duration // closing unused connections
+	MaxIdleConnsPerHost   int           // connections kept open to each peer
+}
+
+// DefaultHTTPClientConfig keeps enough idle connections per peer for the
+// concurrent fan-out of loads and replica writes.
+var DefaultHTTPClientConfig = HTTPClientConfig{
+	DialTimeout:           time.Second,
+	ResponseHeaderTimeout: 2 * time.Second,
+	Timeout:               5 * time.Second,
+	KeepAlive:             30 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   64,
+}
+
+// withDefaults fills the zero fields of c from DefaultHTTPClientConfig.
+func (c HTTPClientConfig) withDefaults() HTTPClientConfig {
+	d := DefaultHTTPClientConfig
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = d.DialTimeout
+	}
+	if c.ResponseHeaderTimeout <= 0 {
+		c.ResponseHeaderTimeout = d.ResponseHeaderTimeout
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = d.KeepAlive
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = d.IdleConnTimeout
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = d.MaxIdleConnsPerHost
+	}
+	return c
+}
+
+// transport builds the peer transport for c.
+func (c HTTPClientConfig) transport() *http.Transport {
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          0, // bounded per host instead
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   c.DialTimeout,
+	}
+}
+
+// WithHTTPClientConfig sets the timeouts and connection limits of peer calls.
+func WithHTTPClientConfig(cfg HTTPClientConfig) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.clientConfig = cfg
+	}
+}
+
+// WithRoundTripper wraps the transport of peer calls, e.g. to add
+// instrumentation or to replace it with a fake in tests. wrap receives the
+// tuned transport built from the client config.
+func WithRoundTripper(wrap func(http.RoundTripper) http.RoundTripper) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.wrapRT = wrap
+	}
+}
+
 type HTTPGetter struct {
-	baseURL string
+	addr       string
+	baseURL    string
+	ringFormat string // sent with every request so peers can reject mismatches
+	client     *http.Client
+	signer     *Signer
+	codings    []string // content codings to compress with and accept
+	threshold  int      // smallest body worth compressing
+	inFlight   atomic.Int64
+
+	attemptTimeout time.Duration // bounds Get, see WithFailover
+	cooldown       time.Duration
+	suspicion
+	breaker *breaker.Breaker
+
+	unhealthy  atomic.Bool   // set by the health checker
+	stopHealth chan struct{} // stops the health checker; guarded by the pool's mu
+}
+
+func newHTTPGetter(p *HTTPPool, addr string) *HTTPGetter {
+	cfg := p.breakerConfig
+	onChange := cfg.OnStateChange
+	cfg.OnStateChange = func(from, to breaker.State) {
+		peerBreakerState.WithLabelValues(addr).Set(float64(to))
+		if onChange != nil {
+			onChange(from, to)
+		}
+	}
+	peerBreakerState.WithLabelValues(addr).Set(float64(breaker.Closed))
+	return &HTTPGetter{
+		addr:           addr,
+		baseURL:        addr + p.basePath,
+		ringFormat:     p.format,
+		client:         p.client,
+		signer:         p.signer,
+		codings:        p.codings,
+		threshold:      p.compressThreshold,
+		attemptTimeout: p.attemptTimeout,
+		cooldown:       p.suspectCooldown,
+		breaker:        breaker.NewWithConfig(cfg),
+	}
+}
+
+// available reports whether loads should be sent to the peer: it passes its
+// health checks, has not failed recently and its circuit is not open.
+func (h *HTTPGetter) available() bool {
+	return h.Healthy() && !h.Suspect() && h.breaker.Ready()
+}
+
+// BreakerState returns the state of the peer's circuit breaker.
+func (h *HTTPGetter) BreakerState() breaker.State {
+	return h.breaker.State()
+}
+
+// do sends a request to the peer tagged with this node's ring format. It
+// bypasses the circuit breaker, which only tracks data reads: writes check it
+// with writable, admin calls ignore it.
+func (h *HTTPGetter) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := h.newRequest(ctx, method, u, body, "")
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
+
+// send sends a data read through the peer's circuit breaker. Transport errors
+// and 5xx replies count as failures. Otherwise the call is observed when the
+// body is closed, so its latency covers the whole body, streamed or not.
+func (h *HTTPGetter) send(req *http.Request) (*http.Response, error) {
+	if err := h.breaker.Allow(); err != nil {
+		peerBreakerRejections.WithLabelValues(h.addr).Inc()
+		return nil, fmt.Errorf("peer %s: %w", h.addr, err)
+	}
+	start := time.Now()
+	res, err := h.client.Do(req)
+	if err != nil {
+		h.breaker.Observe(err, time.Since(start))
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		h.breaker.Observe(fmt.Errorf("server returned: %v", res.Status), time.Since(start))
+		return res, nil
+	}
+	res.Body = &observedBody{ReadCloser: res.Body, observe: func(err error) {
+		h.breaker.Observe(err, time.Since(start))
+	}}
+	return res, nil
+}
+
+// observedBody reports a read to the breaker once its body is closed, with
+// the first read error other than EOF.
+type observedBody struct {
+	io.ReadCloser
+	observe func(err error)
+	err     error
+	once    sync.Once
+}
+
+func (b *observedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.observe(b.err) })
+	return err
+}
+
+// writable fails fast if the peer's circuit is open, so writes skip it
+// without counting towards the breaker.
+func (h *HTTPGetter) writable() error {
+	if !h.breaker.Ready() {
+		peerBreakerRejections.WithLabelValues(h.addr).Inc()
+		return fmt.Errorf("peer %s: %w", h.addr, breaker.ErrOpen)
+	}
+	return nil
+}
+
+// doEncoded is do with body compressed as the pool is configured to.
+func (h *HTTPGetter) doEncoded(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	var c Compressor
+	if len(h.codings) > 0 {
+		c, _ = compressorFor(h.codings[0])
+	}
+	body, coding, err := encodeBody(c, h.threshold, body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := h.newRequest(ctx, method, u, body, coding)
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
+
+// newRequest builds a peer request, tagged with this node's ring format and
+// signed if the pool has a signer. coding names the body's content coding,
+// if any; the request accepts the pool's codings in reply.
+func (h *HTTPGetter) newRequest(ctx context.Context, method, u string, body []byte, coding string) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if h.ringFormat != "" {
+		req.Header.Set(ringFormatHeader, h.ringFormat)
+	}
+	if coding != "" {
+		req.Header.Set("Content-Encoding", coding)
+	}
+	if len(h.codings) > 0 {
+		req.Header.Set("Accept-Encoding", strings.Join(h.codings, ", "))
+	}
+	if h.signer != nil {
+		if err := h.signer.Sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// InFlight returns the number of requests to this peer still in progress.
+func (h *HTTPGetter) InFlight() int64 {
+	return h.inFlight.Load()
 }
 
 func (h *HTTPGetter) Set(in *pb.SetRequest, out *pb.EmptyResponse) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+
 	// Build the POST URL: <baseURL>/<group>/<key>
 	u := fmt.Sprintf(
 		"%s%s/%s",
@@ -42,6 +442,10 @@ func (h *HTTPGetter) Set(in *pb.SetRequest, out *pb.EmptyResponse) error {
 		url.PathEscape(in.Key),
 	)
 
+	if len(in.Value) > chunkSize {
+		return h.setStream(in, u)
+	}
+
 	// Marshal the SetRequest as protobuf
 	body, err := proto.Marshal(in)
 	if err != nil {
@@ -49,7 +453,7 @@ func (h *HTTPGetter) Set(in *pb.SetRequest, out *pb.EmptyResponse) error {
 	}
 
 	// Fire the POST with the protobuf payload
-	resp, err := http.Post(u, "application/octet-stream", bytes.NewReader(body))
+	resp, err := h.doEncoded(context.Background(), http.MethodPost, u, body)
 	if err != nil {
 		return fmt.Errorf("POST to %s failed: %w", u, err)
 	}
@@ -61,12 +465,83 @@ func (h *HTTPGetter) Set(in *pb.SetRequest, out *pb.EmptyResponse) error {
 	return nil
 }
 
+// Delete drops in.Key from the peer's local cache.
+func (h *HTTPGetter) Delete(in *pb.GetRequest, out *pb.EmptyResponse) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	u := fmt.Sprintf(
+		"%s%s/%s",
+		h.baseURL,
+		url.PathEscape(in.Group),
+		url.PathEscape(in.Key),
+	)
+	resp, err := h.do(context.Background(), http.MethodDelete, u, nil)
+	if err != nil {
+		return fmt.Errorf("DELETE to %s failed: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned status %s", u, resp.Status)
+	}
+	return nil
+}
+
 // HTTP Pool
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	pool := &HTTPPool{
 		self:     self,
 		basePath: defaultPath,
+		hashName: defaultHashName,
+		hash:     defaultHash,
+		vnodes:   defaultReplicas,
+
+		attemptTimeout:  defaultAttemptTimeout,
+		suspectCooldown: defaultSuspectCooldown,
+		breakerConfig:   DefaultPeerBreaker,
+	}
+	for _, opt := range opts {
+		opt(pool)
+	}
+	pool.clientConfig = pool.clientConfig.withDefaults()
+	tr := pool.clientConfig.transport()
+	if pool.tls != nil {
+		tr.DialTLSContext = pool.tls.dialTLS(tr)
 	}
+	var rt http.RoundTripper = tr
+	if pool.wrapRT != nil {
+		rt = pool.wrapRT(rt)
+	}
+	pool.client = &http.Client{Transport: rt, Timeout: pool.clientConfig.Timeout}
+	if pool.placement == nil {
+		pool.placement = placement.Ring{Map: consistenthash.NewMap(pool.vnodes, pool.hash), HashName: pool.hashName}
+	}
+	pool.format = placement.Describe(pool.placement)
+	if pool.zoneAware {
+		pool.format += "+zones"
+		pool.placement = placement.NewTopologyAware(pool.placement)
+	}
+	pool.state.Store(&poolState{
+		peers:       pool.placement,
+		httpGetters: make(map[string]*HTTPGetter),
+	})
+	return pool
+}
+
+// ringFormat is the ring format of a default ring hashing with hashName and
+// vnodes virtual nodes per peer.
+func ringFormat(hashName string, vnodes int) string {
+	return fmt.Sprintf("ring/v%d/%s/%d", consistenthash.FormatVersion, hashName, vnodes)
+}
+
+// RingFormat describes how this pool places keys: the placement algorithm
+// and its parameters, such as the hash and vnode count of the default ring
+// or the size of a Maglev table. Peers send it with every request, and both
+// transports refuse requests carrying a different format. Requests without
+// one, e.g. from curl or a load generator, are served.
+func (pool *HTTPPool) RingFormat() string {
+	return pool.format
 }
 
 func (pool *HTTPPool) Log(format string, v ...interface{}) {
@@ -78,9 +553,28 @@ func (pool *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("Unexpected Path: " + r.URL.Path)
 	}
 	pool.Log("%s %s", r.Method, r.URL.Path)
+	if pool.signer != nil {
+		if err := pool.signer.verifyRequest(w, r); err != nil {
+			code := http.StatusUnauthorized
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	format := r.Header.Get(ringFormatHeader)
+	if format != "" && format != pool.format {
+		http.Error(w, fmt.Sprintf("ring format mismatch: peer uses %q, this node %q", format, pool.format), http.StatusConflict)
+		return
+	}
 	// original: /<basepath>/<groupName>/<key>
 	// routes: /<groupName>/<Key>
 	subpath := r.URL.Path[len(pool.basePath):]
+	if pool.serveAdmin(w, r, subpath) {
+		return
+	}
 	routes := strings.SplitN(subpath, "/", 2)
 	if len(routes) < 2 {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -102,19 +596,53 @@ func (pool *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if r.Header.Get(streamHeader) != "" && bv.Len() > chunkSize {
+			c := pool.negotiate(r.Header.Get("Accept-Encoding"))
+			setStreamHeaders(w.Header(), bv, c)
+			w.Header().Add("Vary", "Accept-Encoding")
+			if err := writeStream(w, bv, c); err != nil {
+				pool.Log("streaming %s/%s: %v", groupName, key, err)
+			}
+			return
+		}
 
 		body, err := proto.Marshal(&pb.Response{Value: bv.Bytes()})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		body, coding, err := encodeBody(pool.negotiate(r.Header.Get("Accept-Encoding")), pool.compressThreshold, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/octet-stream")
+		if coding != "" {
+			w.Header().Set("Content-Encoding", coding)
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
 		w.Write(body)
 
 	case http.MethodPost:
-		body, err := io.ReadAll(r.Body)
+		if GetGroup(groupName) == nil {
+			http.Error(w, "Group Not Found: "+groupName, http.StatusNotFound)
+			return
+		}
+		limit := payloadLimit(groupName)
+		if r.Header.Get("Content-Type") == streamContentType {
+			pool.serveStreamedSet(w, r, groupName, key, limit)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if body, err = decodeBody(r.Header, body, limit); errors.Is(err, ErrTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 			return
 		}
 		var req pb.SetRequest
@@ -130,54 +658,288 @@ func (pool *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// Add
 		group.cache.Add(req.Key, ByteView{bytes: []byte(req.Value)})
 		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		group := GetGroup(groupName)
+		if group == nil {
+			http.Error(w, "Group Not Found: "+groupName, http.StatusNotFound)
+			return
+		}
+		group.cache.Remove(key)
+		w.WriteHeader(http.StatusOK)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 
 }
 
+// Set updates the pool's membership to peers, all with weight 1.
 func (p *HTTPPool) Set(peers ...string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.peers = consistenthash.NewMap(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*HTTPGetter, len(peers))
+	weights := make(map[string]int, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &HTTPGetter{baseURL: peer + p.basePath}
+		weights[peer] = 1
 	}
+	p.SetWeighted(weights)
 }
 
-func (p *HTTPPool) PickPeer(key string) (PeerClient, bool) {
+// SetWeighted updates the pool's membership to the given peers, each owning a
+// share of the keyspace proportional to its weight. Peers with a weight of
+// zero or less are dropped.
+func (p *HTTPPool) SetWeighted(peers map[string]int) {
+	var list []Peer
+	for addr, weight := range peers {
+		if weight > 0 {
+			list = append(list, Peer{Addr: addr, Weight: weight})
+		}
+	}
+	p.SetPeers(list...)
+}
+
+// Peer describes a pool member.
+type Peer struct {
+	Addr   string
+	Weight int // relative share of the keyspace on the default ring; 0 means 1
+	placement.Topology
+}
+
+// SetPeers updates the pool's membership. Only the difference from the
+// current membership is applied to the ring, so unaffected keys keep their
+// owners and existing peer clients are reused. Placements other than the
+// default ring ignore weights, and topology labels are only used with
+// WithZoneAwareReplicas. The new membership is published as a new version;
+// lookups in flight finish against the previous one.
+func (p *HTTPPool) SetPeers(peers ...Peer) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	replicas := p.peers.GetReplicas(key, defaultReplicationFactor)
-	for _, peer := range replicas {
-		if peer != "" && peer != p.self {
-			p.Log("Picked peer %s", peer)
-			return p.httpGetters[peer], true
+	old := p.state.Load()
+
+	wanted := make(map[string]Peer, len(peers))
+	nodes := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer.Weight <= 0 {
+			peer.Weight = 1
+		}
+		wanted[peer.Addr] = peer
+		nodes = append(nodes, peer.Addr)
+	}
+
+	next := &poolState{
+		version:     old.version + 1,
+		peers:       old.peers.Clone(),
+		httpGetters: make(map[string]*HTTPGetter, len(wanted)),
+	}
+	if limit := placement.Capacity(next.peers); limit > 0 && len(nodes) > limit {
+		p.Log("placement holds %d of %d peers; the rest get no keys", limit, len(nodes))
+	}
+	next.peers.SetNodes(nodes...)
+	if ring, ok := next.ring(); ok {
+		for _, peer := range wanted {
+			if ring.Weight(peer.Addr) != peer.Weight {
+				ring.AddWeighted(peer.Addr, peer.Weight)
+			}
+		}
+	}
+	if topo, ok := next.peers.(*placement.TopologyAware); ok {
+		for _, peer := range wanted {
+			topo.SetTopology(peer.Addr, peer.Topology)
+		}
+	}
+	for addr := range wanted {
+		if getter, exists := old.httpGetters[addr]; exists {
+			next.httpGetters[addr] = getter
+		} else {
+			next.httpGetters[addr] = newHTTPGetter(p, addr)
+			if addr != p.self {
+				if p.health != nil {
+					p.startHealthCheck(next.httpGetters[addr])
+				}
+				go p.verifyPeer(next.httpGetters[addr])
+			}
+		}
+	}
+	p.state.Store(next)
+	for addr, getter := range old.httpGetters {
+		if _, kept := next.httpGetters[addr]; !kept {
+			peerBreakerState.DeleteLabelValues(addr)
+			p.stopHealthCheck(getter)
 		}
 	}
 
+	for _, g := range registeredGroups(p) {
+		g.recordReplication(len(next.httpGetters))
+	}
+}
+
+// verifyPeer checks that a new peer accepts this node's ring format and logs
+// it if not. Unreachable peers are left to the health checks and failover.
+func (p *HTTPPool) verifyPeer(h *HTTPGetter) {
+	if _, err := h.localStatus("", ""); errors.Is(err, errRingFormat) {
+		ringFormatMismatches.WithLabelValues(h.addr).Inc()
+		p.Log("peer %s: %v", h.addr, err)
+	}
+}
+
+// VerifyPeers asks every other peer to accept this node's ring format and
+// returns the peers that refused or could not be reached. SetPeers runs the
+// same check in the background for every peer it adds.
+func (p *HTTPPool) VerifyPeers() error {
+	var errs []error
+	for addr, getter := range p.state.Load().httpGetters {
+		if addr == p.self {
+			continue
+		}
+		if _, err := getter.localStatus("", ""); err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Version returns the membership version, bumped by every update.
+func (p *HTTPPool) Version() uint64 {
+	return p.state.Load().version
+}
+
+// replicas returns up to n peers that should hold key.
+func (p *HTTPPool) replicas(key string, n int) []string {
+	return p.state.Load().peers.Replicas(key, n)
+}
+
+func (p *HTTPPool) ReplicaPeers(key string, n int) []PeerWriter {
+	state := p.state.Load()
+	var peers []PeerWriter
+	for _, addr := range state.peers.Replicas(key, n) {
+		if addr != p.self {
+			peers = append(peers, state.httpGetters[addr])
+		}
+	}
+	return peers
+}
+
+func (p *HTTPPool) PeerCount() int {
+	return len(p.state.Load().httpGetters)
+}
+
+// Shares reports each peer's expected fraction of the keyspace. Placements
+// other than the default ring balance evenly by design, so each peer is
+// reported with an equal share.
+func (p *HTTPPool) Shares() map[string]float64 {
+	return p.state.Load().shares()
+}
+
+func (s *poolState) shares() map[string]float64 {
+	if ring, ok := s.ring(); ok {
+		return ring.Shares()
+	}
+	shares := make(map[string]float64, len(s.httpGetters))
+	for peer := range s.httpGetters {
+		shares[peer] = 1 / float64(len(s.httpGetters))
+	}
+	return shares
+}
+
+func (p *HTTPPool) PickPeer(key string) (PeerClient, bool) {
+	state := p.state.Load()
+	if peer := p.pick(state, key, defaultReplicationFactor); peer != nil {
+		return peer, true
+	}
 	return nil, false
 }
 
+// PickPeers returns the picked peer followed by the key's other replicas among
+// its first n, skipping this node and suspect peers.
+func (p *HTTPPool) PickPeers(key string, n int) []PeerClient {
+	state := p.state.Load()
+	var peers []PeerClient
+	first := p.pick(state, key, n)
+	if first != nil {
+		peers = append(peers, first)
+	}
+	for _, addr := range state.peers.Replicas(key, n) {
+		getter := state.httpGetters[addr]
+		if addr != p.self && getter != first && getter.available() {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// pick returns the peer to load key from, or nil to load it locally. Without
+// bounded load it is the first available of key's n replicas.
+func (p *HTTPPool) pick(state *poolState, key string, n int) *HTTPGetter {
+	if ring, ok := state.ring(); ok && p.boundedLoad {
+		peer := ring.GetBounded(key, p.loadEpsilon, func(peer string) int64 {
+			return p.load(state, peer)
+		})
+		if peer == "" || peer == p.self || !state.httpGetters[peer].available() {
+			return nil
+		}
+		return state.httpGetters[peer]
+	}
+	for _, peer := range state.peers.Replicas(key, n) {
+		if peer != "" && peer != p.self && state.httpGetters[peer].available() {
+			return state.httpGetters[peer]
+		}
+	}
+	return nil
+}
+
+// trackLocalLoad counts a load this node runs itself until the returned
+// func is called, so bounded-load picks see this node's load the same way
+// they see a peer's: as loads this node has placed on it.
+func (p *HTTPPool) trackLocalLoad() func() {
+	p.selfInFlight.Add(1)
+	return func() { p.selfInFlight.Add(-1) }
+}
+
+// load reports a peer's in-flight requests as seen from this node.
+func (p *HTTPPool) load(state *poolState, peer string) int64 {
+	if peer == p.self {
+		return p.selfInFlight.Load()
+	}
+	if getter, ok := state.httpGetters[peer]; ok {
+		return getter.InFlight()
+	}
+	return 0
+}
+
 // HTTP Getter
 func (h *HTTPGetter) Get(in *pb.GetRequest, out *pb.Response) error {
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
+
+	ctx := context.Background()
+	if h.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.attemptTimeout)
+		defer cancel()
+	}
 	u := fmt.Sprintf("%v%v/%v", h.baseURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
-	res, err := http.Get(u)
+	req, err := h.newRequest(ctx, http.MethodGet, u, nil, "")
 	if err != nil {
 		return err
 	}
+	res, err := h.send(req)
+	if err != nil {
+		// Unreachable or too slow: let later picks skip this peer.
+		if !errors.Is(err, breaker.ErrOpen) {
+			h.suspect(h.cooldown)
+		}
+		return err
+	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	bytes, err := io.ReadAll(res.Body)
+	limit := payloadLimit(in.GetGroup())
+	bytes, err := readLimited(res.Body, limit)
 	if err != nil {
 		return fmt.Errorf("reading resbonse body: %v", err)
 	}
+	if bytes, err = decodeBody(res.Header, bytes, limit); err != nil {
+		return fmt.Errorf("decompressing response body: %v", err)
+	}
 
 	if err = proto.Unmarshal(bytes, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
@@ -187,3 +949,6 @@ func (h *HTTPGetter) Get(in *pb.GetRequest, out *pb.Response) error {
 
 // Compile time assertion
 var _ PeerClient = (*HTTPGetter)(nil)
+var _ PeerWriter = (*HTTPGetter)(nil)
+var _ ReplicaPicker = (*HTTPPool)(nil)
+var _ FailoverPicker = (*HTTPPool)(nil)
@@ -0,0 +1,591 @@
+package cache
+
+import (
+	"distributed-cache/cache/breaker"
+	pb "distributed-cache/cache/pb"
+	"distributed-cache/cache/placement"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPickPeerBoundedLoad(t *testing.T) {
+	pool := NewHTTPPool("http://a", WithBoundedLoad(0.25))
+	pool.Set("http://a", "http://b", "http://c")
+	state := pool.state.Load()
+
+	// Fixed keys: one owned by a peer, one owned by this node.
+	peerKey, selfKey := ownedKey(t, state, "http://b"), ownedKey(t, state, "http://a")
+	owner := state.httpGetters["http://b"]
+	if peer, ok := pool.PickPeer(peerKey); !ok || peer != owner {
+		t.Fatalf("idle pool should pick the ring owner http://b")
+	}
+	if _, ok := pool.PickPeer(selfKey); ok {
+		t.Fatal("idle pool should load keys it owns locally")
+	}
+
+	owner.inFlight.Add(10)
+	if peer, ok := pool.PickPeer(peerKey); ok && peer == owner {
+		t.Fatal("overloaded owner http://b should be skipped")
+	}
+	owner.inFlight.Add(-10)
+
+	// Local loads count against this node just like calls count against a peer.
+	var done []func()
+	for i := 0; i < 10; i++ {
+		done = append(done, pool.trackLocalLoad())
+	}
+	if _, ok := pool.PickPeer(selfKey); !ok {
+		t.Fatal("overloaded self should hand its keys to a peer")
+	}
+	for _, d := range done {
+		d()
+	}
+	if _, ok := pool.PickPeer(selfKey); ok {
+		t.Fatal("self should take its keys back once its loads finish")
+	}
+}
+
+// ownedKey returns the first of a fixed sequence of keys that node owns.
+func ownedKey(t *testing.T, state *poolState, node string) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprintf("key-%d", i); state.peers.Owner(key) == node {
+			return key
+		}
+	}
+	t.Fatalf("no key owned by %s", node)
+	return ""
+}
+
+// startPools serves one HTTPPool per node on local test servers, all sharing
+// this process's groups.
+func startPools(t *testing.T, n int, opts ...HTTPPoolOption) []*HTTPPool {
+	t.Helper()
+	pools := make([]*HTTPPool, n)
+	addrs := make([]string, n)
+	for i := range pools {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pools[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		addrs[i] = srv.URL
+	}
+	for i := range pools {
+		pools[i] = NewHTTPPool(addrs[i], opts...)
+		pools[i].Set(addrs...)
+	}
+	return pools
+}
+
+func TestRingStatus(t *testing.T) {
+	pools := startPools(t, 3)
+	group := NewGroup("ring-status", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	group.cache.Add("Tom", ByteView{bytes: []byte("631")})
+
+	status := pools[0].RingStatus("ring-status", "Tom")
+	if len(status.Nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(status.Nodes))
+	}
+	var share float64
+	for _, node := range status.Nodes {
+		if node.Error != "" || node.Vnodes != defaultReplicas || node.Keys == 0 {
+			t.Fatalf("unexpected node status %+v", node)
+		}
+		share += node.Share
+	}
+	if share < 0.999 || share > 1.001 {
+		t.Fatalf("shares should sum to 1, got %.4f", share)
+	}
+	if status.Key.Owner != pools[0].state.Load().peers.Owner("Tom") || len(status.Key.Replicas) != 3 {
+		t.Fatalf("unexpected key status %+v", status.Key)
+	}
+	for _, rs := range status.Key.Replicas {
+		if !rs.Present {
+			t.Fatalf("replica %s should report Tom present", rs.Addr)
+		}
+	}
+
+	res, err := http.Get(pools[1].self + defaultPath + ringPath + "?key=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var remote RingStatus
+	if err := json.NewDecoder(res.Body).Decode(&remote); err != nil {
+		t.Fatal(err)
+	}
+	if remote.Self != pools[1].self || remote.Key == nil || remote.Key.Replicas[0].Present {
+		t.Fatalf("unexpected admin response %+v", remote)
+	}
+}
+
+func TestAdminMethodCheckedFirst(t *testing.T) {
+	var calls atomic.Int32
+	pools := startPools(t, 2, WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Query().Get("key") == "k" {
+				calls.Add(1)
+			}
+			return base.RoundTrip(r)
+		})
+	}))
+	res, err := http.Post(pools[0].self+defaultPath+ringPath+"?key=k", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || calls.Load() != 0 {
+		t.Fatalf("POST should be refused before any fan-out, got %s after %d peer calls", res.Status, calls.Load())
+	}
+}
+
+// TestConcurrentMembership is meant for go test -race: lookups and
+// replication run while the membership keeps changing.
+func TestConcurrentMembership(t *testing.T) {
+	// Replica writes fail at once instead of dialing, so none outlive the test.
+	pool := NewHTTPPool("http://127.0.0.1:1", WithRoundTripper(func(http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("no network in this test")
+		})
+	}))
+	t.Cleanup(pool.Close)
+	pool.Set("http://127.0.0.1:1", "http://127.0.0.1:2")
+	group := NewGroup("membership-race", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	group.RegisterPeers(pool)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				pool.PickPeer(key)
+				pool.replicas(key, 3)
+				group.replicate(key, []byte(key))
+			}
+		}(i)
+	}
+
+	start := pool.Version()
+	for i := 0; i < 50; i++ {
+		if i%2 == 0 {
+			pool.Set("http://127.0.0.1:1", "http://127.0.0.1:2", "http://127.0.0.1:3")
+		} else {
+			pool.Set("http://127.0.0.1:1", "http://127.0.0.1:2")
+		}
+	}
+	close(done)
+	wg.Wait()
+	if pool.Version() != start+50 {
+		t.Fatalf("every update should publish a new version, got %d", pool.Version()-start)
+	}
+}
+
+func BenchmarkPickPeerParallel(b *testing.B) {
+	pool := NewHTTPPool("http://node-0")
+	peers := make([]string, 10)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("http://node-%d", i)
+	}
+	pool.Set(peers...)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			pool.PickPeer(keys[i%len(keys)])
+		}
+	})
+}
+
+func TestRingFormatMismatch(t *testing.T) {
+	pools := startPools(t, 2)
+	if err := pools[0].VerifyPeers(); err != nil {
+		t.Fatalf("pools with the same format should accept each other: %v", err)
+	}
+
+	// Rebuild the second node with a different hash behind the same address.
+	// Adding the peer checks its format in the background.
+	mismatches := ringFormatMismatches.WithLabelValues(pools[0].self)
+	before := testutil.ToFloat64(mismatches)
+	pools[1] = NewHTTPPool(pools[1].self, WithHash("crc32", crc32.ChecksumIEEE))
+	pools[1].Set(pools[0].self, pools[1].self)
+	if pools[0].RingFormat() == pools[1].RingFormat() {
+		t.Fatalf("hash should be part of the ring format")
+	}
+	if err := pools[0].VerifyPeers(); !errors.Is(err, errRingFormat) {
+		t.Fatalf("peer with a different hash should be refused, got %v", err)
+	}
+	getter := pools[0].state.Load().httpGetters[pools[1].self]
+	if err := getter.Get(&pb.GetRequest{Group: "any", Key: "k"}, &pb.Response{}); err == nil {
+		t.Fatalf("peer request across ring formats should fail")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(mismatches) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("adding a peer with another format should be reported")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Clients that are not peers, e.g. curl, send no format and are served.
+	NewGroup("format-free", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	res, err := http.Get(pools[0].self + defaultPath + "format-free/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("data request without a ring format should be served, got %s", res.Status)
+	}
+}
+
+func TestRingFormatParameters(t *testing.T) {
+	formats := make(map[string]bool)
+	for _, pool := range []*HTTPPool{
+		NewHTTPPool("http://a"),
+		NewHTTPPool("http://a", WithPlacement(placement.NewMaglev(13))),
+		NewHTTPPool("http://a", WithPlacement(placement.NewMaglev(65537))),
+		NewHTTPPool("http://a", WithPlacement(placement.NewTopologyAware(placement.NewMaglev(13)))),
+		NewHTTPPool("http://a", WithPlacement(placement.NewTopologyAware(placement.NewRendezvous()))),
+	} {
+		formats[pool.RingFormat()] = true
+	}
+	if len(formats) != 5 {
+		t.Fatalf("placements with different parameters should have different formats, got %v", formats)
+	}
+}
+
+func TestMaglevMembershipPastCapacity(t *testing.T) {
+	pool := NewHTTPPool("http://a", WithPlacement(placement.NewMaglev(5)))
+	pool.Set("http://a", "http://b", "http://c", "http://d", "http://e", "http://f")
+	if got := pool.replicas("key", 6); len(got) != 4 {
+		t.Fatalf("expected keys placed on the 4 peers the table holds, got %v", got)
+	}
+}
+
+// isAdmin reports whether r is for an admin route, like the background
+// format checks made when peers are added.
+func isAdmin(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, defaultPath+"_")
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestPeerClientRoundTripper(t *testing.T) {
+	var calls atomic.Int32
+	pool := NewHTTPPool("http://a", WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if !isAdmin(r) {
+				calls.Add(1)
+			}
+			return base.RoundTrip(r)
+		})
+	}))
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	NewGroup("round-tripper", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pool.Set(srv.URL)
+
+	var res pb.Response
+	getter := pool.state.Load().httpGetters[srv.URL]
+	if err := getter.Get(&pb.GetRequest{Group: "round-tripper", Key: "k"}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value) != "k" || calls.Load() != 1 {
+		t.Fatalf("got %q after %d round trips", res.Value, calls.Load())
+	}
+	if _, ok := pool.client.Transport.(roundTripFunc); !ok {
+		t.Fatalf("transport not wrapped: %T", pool.client.Transport)
+	}
+}
+
+func TestPeerClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	pool := NewHTTPPool("http://a", WithHTTPClientConfig(HTTPClientConfig{
+		ResponseHeaderTimeout: 50 * time.Millisecond,
+	}))
+	if cfg := pool.clientConfig; cfg.Timeout != DefaultHTTPClientConfig.Timeout ||
+		cfg.MaxIdleConnsPerHost != DefaultHTTPClientConfig.MaxIdleConnsPerHost {
+		t.Fatalf("unset fields should take defaults, got %+v", cfg)
+	}
+	pool.Set(slow.URL)
+
+	start := time.Now()
+	err := pool.state.Load().httpGetters[slow.URL].Get(&pb.GetRequest{Group: "g", Key: "k"}, &pb.Response{})
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+}
+
+func TestPickPeersSkipsSuspects(t *testing.T) {
+	var down atomic.Value
+	down.Store("")
+	pools := startPools(t, 4, WithFailover(100*time.Millisecond, time.Minute),
+		WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if "http://"+r.URL.Host == down.Load() {
+					return nil, fmt.Errorf("connection refused")
+				}
+				return base.RoundTrip(r)
+			})
+		}))
+	NewGroup("suspects", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	pool := pools[0]
+
+	var key string
+	var peers []PeerClient
+	for i := 0; len(peers) < 2; i++ {
+		key = fmt.Sprintf("key-%d", i)
+		peers = pool.PickPeers(key, defaultReplicationFactor)
+	}
+	first := peers[0].(*HTTPGetter)
+	down.Store(strings.TrimSuffix(first.baseURL, defaultPath))
+
+	if err := first.Get(&pb.GetRequest{Group: "suspects", Key: key}, &pb.Response{}); err == nil {
+		t.Fatal("expected the downed peer to fail")
+	}
+	if !first.Suspect() {
+		t.Fatal("failed peer should be suspect")
+	}
+	if peer, ok := pool.PickPeer(key); !ok || peer != peers[1] {
+		t.Fatal("PickPeer should move on to the next replica")
+	}
+	for _, peer := range pool.PickPeers(key, defaultReplicationFactor) {
+		if peer == first {
+			t.Fatal("PickPeers should skip the suspect peer")
+		}
+	}
+}
+
+func TestPickPeersReplicationFactor(t *testing.T) {
+	pools := startPools(t, 6)
+	pool := pools[0]
+	state := pool.state.Load()
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if slices.Contains(state.peers.Replicas(key, 5), pool.self) {
+			continue
+		}
+		if peers := pool.PickPeers(key, 5); len(peers) != 5 {
+			t.Fatalf("expected all 5 replicas as candidates, got %d", len(peers))
+		}
+		return
+	}
+}
+
+func TestPeerBreaker(t *testing.T) {
+	var down atomic.Value
+	down.Store("")
+	pools := startPools(t, 2, WithPeerBreaker(breaker.Config{ConsecutiveFailures: 2, Cooldown: time.Minute}),
+		WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if "http://"+r.URL.Host == down.Load() {
+					return nil, fmt.Errorf("connection refused")
+				}
+				return base.RoundTrip(r)
+			})
+		}))
+	peer := pools[0].state.Load().httpGetters[pools[1].self]
+	down.Store(pools[1].self)
+
+	req := &pb.GetRequest{Group: "breaker", Key: "k"}
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(req, &pb.Response{}); errors.Is(err, breaker.ErrOpen) {
+			t.Fatalf("call %d rejected before the breaker opened", i)
+		}
+	}
+	if peer.BreakerState() != breaker.Open {
+		t.Fatalf("expected open breaker, got %s", peer.BreakerState())
+	}
+	if err := peer.Get(req, &pb.Response{}); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("expected ErrOpen, got %v", err)
+	}
+	if got := testutil.ToFloat64(peerBreakerState.WithLabelValues(pools[1].self)); got != float64(breaker.Open) {
+		t.Fatalf("breaker gauge = %v, want %v", got, float64(breaker.Open))
+	}
+	for _, node := range pools[0].RingStatus("", "").Nodes {
+		if node.Addr == pools[1].self && node.Breaker != "open" {
+			t.Fatalf("admin status should show the open breaker, got %q", node.Breaker)
+		}
+	}
+	peer.suspicion.until.Store(0)
+	if _, ok := pools[0].PickPeer("k"); ok {
+		t.Fatal("picks should skip a peer with an open circuit")
+	}
+}
+
+func TestPeerBreakerCountsServerErrors(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(nil)
+	}))
+	defer srv.Close()
+	pool := NewHTTPPool("http://self", WithPeerBreaker(breaker.Config{ConsecutiveFailures: 2, Cooldown: time.Minute}))
+	pool.Set("http://self", srv.URL)
+	peer := pool.state.Load().httpGetters[srv.URL]
+
+	// Admin and write failures leave the read breaker alone.
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		peer.localStatus("", "")
+		peer.Delete(&pb.GetRequest{Group: "g", Key: "k"}, &pb.EmptyResponse{})
+	}
+	if peer.BreakerState() != breaker.Closed {
+		t.Fatalf("admin and write failures should not open the breaker, got %s", peer.BreakerState())
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(&pb.GetRequest{Group: "g", Key: "k"}, &pb.Response{}); err == nil {
+			t.Fatal("expected the 503 to fail the load")
+		}
+	}
+	if peer.BreakerState() != breaker.Open {
+		t.Fatalf("503 replies should open the breaker, got %s", peer.BreakerState())
+	}
+	if err := peer.Delete(&pb.GetRequest{Group: "g", Key: "k"}, &pb.EmptyResponse{}); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("writes should skip a peer with an open circuit, got %v", err)
+	}
+}
+
+func TestPeerBreakerTimesWholeBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", streamContentType)
+		w.Header().Set(streamLengthHeader, "9")
+		w.(http.Flusher).Flush()
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte("slow body"))
+	}))
+	defer srv.Close()
+	pool := NewHTTPPool("http://self", WithPeerBreaker(breaker.Config{
+		Cooldown: time.Minute, Window: time.Minute, MinCalls: 1, SlowCall: 10 * time.Millisecond, SlowRate: 0.5,
+	}))
+	pool.Set("http://self", srv.URL)
+	peer := pool.state.Load().httpGetters[srv.URL]
+
+	r, err := peer.GetStream(&pb.GetRequest{Group: "g", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.BreakerState() != breaker.Closed {
+		t.Fatal("the call should not be judged before its body is read")
+	}
+	io.ReadAll(r)
+	r.Close()
+	if peer.BreakerState() != breaker.Open {
+		t.Fatalf("a slow body should count as a slow call, got %s", peer.BreakerState())
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var down atomic.Value
+	down.Store("")
+	events := make(chan HealthEvent, 4)
+	pools := startPools(t, 2,
+		WithHealthCheck(HealthConfig{
+			Interval:           5 * time.Millisecond,
+			Timeout:            50 * time.Millisecond,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+			OnChange:           func(e HealthEvent) { events <- e },
+		}),
+		WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if "http://"+r.URL.Host == down.Load() {
+					return nil, fmt.Errorf("connection refused")
+				}
+				return base.RoundTrip(r)
+			})
+		}))
+	for _, pool := range pools {
+		t.Cleanup(pool.Close)
+	}
+	pool, peer := pools[0], pools[1].self
+
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key-%d", i)
+		if _, ok := pool.PickPeer(key); ok {
+			break
+		}
+	}
+
+	down.Store(peer)
+	select {
+	case e := <-events:
+		if e.Peer != peer || e.Healthy || e.Err == nil {
+			t.Fatalf("expected %s to go down, got %+v", peer, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no down event")
+	}
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatal("unhealthy peer should be left out of routing")
+	}
+	if got := testutil.ToFloat64(peerHealthy.WithLabelValues(peer)); got != 0 {
+		t.Fatalf("health gauge = %v, want 0", got)
+	}
+
+	down.Store("")
+	select {
+	case e := <-events:
+		if e.Peer != peer || !e.Healthy {
+			t.Fatalf("expected %s to come back, got %+v", peer, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no up event")
+	}
+	if _, ok := pool.PickPeer(key); !ok {
+		t.Fatal("recovered peer should be routed to again")
+	}
+}
@@ -40,6 +40,14 @@ func (c *LRUCache) Get(key string) (value Value, exists bool) {
 	return
 }
 
+// Peek returns the value for key without marking it as recently used.
+func (c *LRUCache) Peek(key string) (value Value, exists bool) {
+	if element, exists := c.cache[key]; exists {
+		return element.Value.(*entry).value, true
+	}
+	return
+}
+
 func (c *LRUCache) RemoveOldest() {
 	element := c.list.Back()
 	if element != nil {
@@ -53,6 +61,16 @@ func (c *LRUCache) RemoveOldest() {
 	}
 }
 
+// Remove deletes key from the cache without calling OnEvicted.
+func (c *LRUCache) Remove(key string) {
+	if element, exists := c.cache[key]; exists {
+		c.list.Remove(element)
+		delete(c.cache, key)
+		kv := element.Value.(*entry)
+		c.size -= int64(len(kv.key)) + int64(kv.value.Len())
+	}
+}
+
 func (c *LRUCache) Add(key string, value Value) {
 	if element, exists := c.cache[key]; exists {
 		c.list.MoveToFront(element)
@@ -71,4 +89,9 @@ func (c *LRUCache) Add(key string, value Value) {
 
 func (c *LRUCache) Len() int {
 	return c.list.Len()
+}
+
+// Size returns the bytes used by keys and values.
+func (c *LRUCache) Size() int64 {
+	return c.size
 }
\ No newline at end of file
@@ -51,4 +51,12 @@ func TestOnEvicted(t *testing.T) {
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
-}
\ No newline at end of file
+}
+func TestRemove(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Remove("key1")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.size != 0 {
+		t.Fatalf("Remove key1 failed")
+	}
+}
@@ -19,10 +19,131 @@ var (
 		},
 		[]string{"handler"},
 	)
+
+	staleServes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "group",
+			Name:      "stale_serves_total",
+			Help:      "Expired values served because a reload failed.",
+		},
+		[]string{"group"},
+	)
+
+	earlyRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "group",
+			Name:      "early_refreshes_total",
+			Help:      "Background reloads triggered by probabilistic early expiration.",
+		},
+		[]string{"group"},
+	)
+
+	underReplicated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dcache",
+			Subsystem: "group",
+			Name:      "under_replicated",
+			Help:      "1 when a group has fewer peers than its replication factor.",
+		},
+		[]string{"group"},
+	)
+
+	hedges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "group",
+			Name:      "hedges_total",
+			Help:      "Hedged peer loads by outcome: won, lost, failed or over_budget.",
+		},
+		[]string{"group", "outcome"},
+	)
+
+	peerBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dcache",
+			Subsystem: "peer",
+			Name:      "breaker_state",
+			Help:      "Circuit breaker state per peer: 0 closed, 1 open, 2 half-open.",
+		},
+		[]string{"peer"},
+	)
+
+	peerBreakerRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "peer",
+			Name:      "breaker_rejections_total",
+			Help:      "Peer calls rejected because the peer's circuit was open.",
+		},
+		[]string{"peer"},
+	)
+
+	peerHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "dcache",
+			Subsystem: "peer",
+			Name:      "healthy",
+			Help:      "1 while a peer passes its health checks, 0 while it fails them.",
+		},
+		[]string{"peer"},
+	)
+
+	peerHealthTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "peer",
+			Name:      "health_transitions_total",
+			Help:      "Peer health transitions, by the state entered: up or down.",
+		},
+		[]string{"peer", "state"},
+	)
+
+	ringFormatMismatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "peer",
+			Name:      "ring_format_mismatches_total",
+			Help:      "Peers that refused this node's ring format when added.",
+		},
+		[]string{"peer"},
+	)
+
+	tlsReloadFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "dcache",
+			Subsystem: "tls",
+			Name:      "reload_failures_total",
+			Help:      "Changed certificate files that failed to load; the previous ones stay in use.",
+		},
+	)
+
+	getterLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "dcache",
+			Subsystem: "getter",
+			Name:      "latency_seconds",
+			Help:      "Latency distribution of origin Getter calls.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 15),
+		},
+		[]string{"name", "outcome"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(requestLatency)
+	prometheus.MustRegister(staleServes)
+	prometheus.MustRegister(earlyRefreshes)
+	prometheus.MustRegister(getterLatency)
+	prometheus.MustRegister(underReplicated)
+	prometheus.MustRegister(hedges)
+	prometheus.MustRegister(peerBreakerState)
+	prometheus.MustRegister(peerBreakerRejections)
+	prometheus.MustRegister(peerHealthy)
+	prometheus.MustRegister(peerHealthTransitions)
+	prometheus.MustRegister(ringFormatMismatches)
+	prometheus.MustRegister(tlsReloadFailures)
 }
 
 func InstrumentHandler(name string, handler http.Handler) http.Handler {
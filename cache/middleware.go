@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"distributed-cache/cache/breaker"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Middleware wraps a Getter with extra behaviour such as retries or metrics.
+type Middleware func(Getter) Getter
+
+// Chain composes middlewares so that the first one is the outermost.
+func Chain(mws ...Middleware) Middleware {
+	return func(next Getter) Getter {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// WithMiddleware wraps the group's Getter with mws, first one outermost.
+func WithMiddleware(mws ...Middleware) GroupOption {
+	return func(g *Group) {
+		g.getter = Chain(mws...)(g.getter)
+	}
+}
+
+// ErrGetterTimeout is returned by the Timeout middleware.
+var ErrGetterTimeout = errors.New("getter timed out")
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// Classify marks errors for which isPermanent returns true as permanent, so
+// Retry gives up on them immediately.
+func Classify(isPermanent func(error) bool) Middleware {
+	return func(next Getter) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			data, err := next.Get(key)
+			if err != nil && isPermanent(err) {
+				return nil, Permanent(err)
+			}
+			return data, err
+		})
+	}
+}
+
+// Retry calls the getter up to attempts times. Between attempts it sleeps a
+// random duration up to base*2^n, capped at max (exponential backoff with
+// full jitter). Permanent errors are returned at once. attempts below 1 are
+// treated as 1.
+func Retry(attempts int, base, max time.Duration) Middleware {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(next Getter) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			var err error
+			for i := 0; i < attempts; i++ {
+				if i > 0 {
+					backoff := base << (i - 1)
+					if backoff > max || backoff <= 0 {
+						backoff = max
+					}
+					if backoff > 0 {
+						time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+					}
+				}
+				var data []byte
+				if data, err = next.Get(key); err == nil || IsPermanent(err) {
+					return data, err
+				}
+			}
+			return nil, err
+		})
+	}
+}
+
+// Timeout fails a call that takes longer than d. The Getter interface has no
+// cancellation, so the underlying call keeps running in the background.
+func Timeout(d time.Duration) Middleware {
+	return func(next Getter) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			type result struct {
+				data []byte
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				data, err := next.Get(key)
+				done <- result{data, err}
+			}()
+
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case r := <-done:
+				return r.data, r.err
+			case <-timer.C:
+				return nil, fmt.Errorf("key %q: %w after %v", key, ErrGetterTimeout, d)
+			}
+		})
+	}
+}
+
+// CircuitBreaker stops calling the origin after threshold consecutive
+// failures and probes it again after cooldown. Permanent errors, such as a
+// missing key, show the origin is answering, so they count as successes.
+// Rejected calls fail with a permanent error wrapping breaker.ErrOpen.
+func CircuitBreaker(threshold int, cooldown time.Duration) Middleware {
+	return func(next Getter) Getter {
+		b := breaker.New(threshold, cooldown)
+		return GetterFunc(func(key string) ([]byte, error) {
+			if err := b.Allow(); err != nil {
+				return nil, Permanent(err)
+			}
+			data, err := next.Get(key)
+			if IsPermanent(err) {
+				b.Record(nil)
+			} else {
+				b.Record(err)
+			}
+			return data, err
+		})
+	}
+}
+
+// LatencyHistogram records the latency of each call in the
+// dcache_getter_latency_seconds histogram, labelled with name and outcome.
+func LatencyHistogram(name string) Middleware {
+	return func(next Getter) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			start := time.Now()
+			data, err := next.Get(key)
+			outcome := "ok"
+			if err != nil {
+				outcome = "error"
+			}
+			getterLatency.WithLabelValues(name, outcome).Observe(time.Since(start).Seconds())
+			return data, err
+		})
+	}
+}
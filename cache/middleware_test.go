@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"distributed-cache/cache/breaker"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next Getter) Getter {
+			return GetterFunc(func(key string) ([]byte, error) {
+				order = append(order, name)
+				return next.Get(key)
+			})
+		}
+	}
+	g := Chain(tag("a"), tag("b"))(GetterFunc(func(key string) ([]byte, error) {
+		order = append(order, "getter")
+		return []byte(key), nil
+	}))
+	g.Get("k")
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "getter" {
+		t.Fatalf("unexpected call order %v", order)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	flaky := GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("flaky")
+		}
+		return []byte("ok"), nil
+	})
+	if v, err := Retry(3, time.Millisecond, 5*time.Millisecond)(flaky).Get("k"); err != nil || string(v) != "ok" {
+		t.Fatalf("retry should succeed on third attempt, got %q %v", v, err)
+	}
+
+	calls = 0
+	permanent := GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return nil, Permanent(errors.New("not found"))
+	})
+	if _, err := Retry(3, time.Millisecond, 5*time.Millisecond)(permanent).Get("k"); err == nil || calls != 1 {
+		t.Fatalf("permanent errors should not be retried, calls = %d", calls)
+	}
+
+	calls = 0
+	if _, err := Retry(0, time.Millisecond, 5*time.Millisecond)(permanent).Get("k"); err == nil || calls != 1 {
+		t.Fatalf("zero attempts should still call once, calls = %d, err = %v", calls, err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+		return []byte("late"), nil
+	})
+	if _, err := Timeout(5 * time.Millisecond)(slow).Get("k"); !errors.Is(err, ErrGetterTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	calls := 0
+	down := GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return nil, errors.New("down")
+	})
+	g := CircuitBreaker(2, time.Minute)(down)
+	for i := 0; i < 5; i++ {
+		g.Get("k")
+	}
+	if calls != 2 {
+		t.Fatalf("open breaker should stop calls to the origin, calls = %d", calls)
+	}
+}
+
+func TestCircuitBreakerPermanentErrors(t *testing.T) {
+	calls := 0
+	missing := GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return nil, Permanent(errors.New("not found"))
+	})
+	g := CircuitBreaker(2, time.Minute)(missing)
+	for i := 0; i < 5; i++ {
+		if _, err := g.Get("k"); errors.Is(err, breaker.ErrOpen) {
+			t.Fatal("lookups of missing keys should not open the breaker")
+		}
+	}
+	if calls != 5 {
+		t.Fatalf("expected every lookup to reach the origin, calls = %d", calls)
+	}
+}
@@ -1,6 +1,10 @@
 package cache
 
-import pb "distributed-cache/cache/pb"
+import (
+	pb "distributed-cache/cache/pb"
+	"sync/atomic"
+	"time"
+)
 
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerClient, exists bool)
@@ -10,3 +14,71 @@ type PeerClient interface {
 	// Get(group string, key string) ([]byte, error)
 	Get(in *pb.GetRequest, out *pb.Response) error
 }
+
+// PeerWriter is implemented by peer clients that accept writes.
+type PeerWriter interface {
+	Set(in *pb.SetRequest, out *pb.EmptyResponse) error
+	Delete(in *pb.GetRequest, out *pb.EmptyResponse) error
+}
+
+// ReplicaPicker is implemented by peer pickers that know each key's replicas,
+// so a Group can push writes to them.
+type ReplicaPicker interface {
+	PeerPicker
+	// ReplicaPeers returns clients for key's first n replicas, skipping this
+	// node.
+	ReplicaPeers(key string, n int) []PeerWriter
+	// PeerCount returns the number of nodes, this one included.
+	PeerCount() int
+}
+
+// FailoverPicker is implemented by peer pickers that can offer more than one
+// peer for a key, so a failed peer load moves on to the next replica.
+type FailoverPicker interface {
+	PeerPicker
+	// PickPeers returns clients for key's first n replicas other than this
+	// node, in the order they should be tried, skipping suspect peers. n is
+	// the calling group's replication factor.
+	PickPeers(key string, n int) []PeerClient
+}
+
+// deleteChecker is implemented by peer pickers that cannot drop keys from
+// peers. Group.Delete fails with checkDelete's error rather than leave peers
+// serving a deleted value.
+type deleteChecker interface {
+	checkDelete() error
+}
+
+// localLoadTracker is implemented by peer pickers that weigh this node's own
+// loads when balancing, see WithBoundedLoad.
+type localLoadTracker interface {
+	trackLocalLoad() (done func())
+}
+
+// suspicion marks a peer whose calls failed, so picks skip it until the
+// cooldown passes.
+type suspicion struct {
+	until atomic.Int64 // unix nanos
+}
+
+func (s *suspicion) suspect(cooldown time.Duration) {
+	s.until.Store(nowFunc().Add(cooldown).UnixNano())
+}
+
+// Suspect reports whether the peer failed within its cooldown.
+func (s *suspicion) Suspect() bool {
+	return nowFunc().UnixNano() < s.until.Load()
+}
+
+// registeredGroups returns the groups that use picker.
+func registeredGroups(picker PeerPicker) []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	var registered []*Group
+	for _, g := range groups {
+		if g.peers == picker {
+			registered = append(registered, g)
+		}
+	}
+	return registered
+}
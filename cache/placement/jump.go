@@ -0,0 +1,59 @@
+package placement
+
+// Jump is jump consistent hashing (Lamping and Veach). It needs no memory
+// beyond the node list and balances almost perfectly, but buckets are
+// numbered: nodes are kept sorted, so adding or removing any node other than
+// the last in that order moves more than 1/N of the keys. It suits clusters
+// that grow and shrink at the end.
+type Jump struct {
+	nodes []string
+}
+
+func NewJump(nodes ...string) *Jump {
+	j := &Jump{}
+	j.SetNodes(nodes...)
+	return j
+}
+
+func (j *Jump) SetNodes(nodes ...string) {
+	j.nodes = sortedUnique(nodes)
+}
+
+func (j *Jump) Clone() Placement {
+	clone := *j
+	return &clone
+}
+
+func (j *Jump) bucket(key string) int {
+	h := hash64(key)
+	var b, next int64 = -1, 0
+	for next < int64(len(j.nodes)) {
+		b = next
+		h = h*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((h>>33)+1)))
+	}
+	return int(b)
+}
+
+func (j *Jump) Owner(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[j.bucket(key)]
+}
+
+// Replicas returns the owner followed by the next buckets in order.
+func (j *Jump) Replicas(key string, n int) []string {
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	b := j.bucket(key)
+	replicas := make([]string, n)
+	for i := range replicas {
+		replicas[i] = j.nodes[(b+i)%len(j.nodes)]
+	}
+	return replicas
+}
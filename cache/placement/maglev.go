@@ -0,0 +1,122 @@
+package placement
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// DefaultMaglevTableSize is a prime well above 100 times a typical cluster
+// size, as the Maglev paper recommends.
+const DefaultMaglevTableSize = 65537
+
+// Maglev is Google's Maglev lookup-table hashing: each node fills table slots
+// in its own pseudo-random permutation order, giving O(1) lookups and near
+// perfect balance at the cost of a fixed-size table and slightly more key
+// movement than a ring on membership changes.
+type Maglev struct {
+	size  uint64 // must be prime
+	nodes []string
+	table []int // slot -> index into nodes
+}
+
+// NewMaglev builds a table of size slots; size must be prime. Zero uses
+// DefaultMaglevTableSize. It panics if size is not prime, since the slot
+// permutations would not cover the table.
+func NewMaglev(size uint64, nodes ...string) *Maglev {
+	if size == 0 {
+		size = DefaultMaglevTableSize
+	}
+	if size > 1<<63-1 || !big.NewInt(int64(size)).ProbablyPrime(0) {
+		panic(fmt.Sprintf("placement: Maglev table size %d is not prime", size))
+	}
+	m := &Maglev{size: size}
+	m.SetNodes(nodes...)
+	return m
+}
+
+// Capacity returns the most nodes the table gives slots to: one fewer than
+// its size.
+func (m *Maglev) Capacity() int {
+	return int(m.size - 1)
+}
+
+// SetNodes rebuilds the table. Past Capacity, only the first nodes in sorted
+// order are given slots, so every member still builds the same table; the
+// rest own no keys until the membership shrinks.
+func (m *Maglev) SetNodes(nodes ...string) {
+	nodes = sortedUnique(nodes)
+	if len(nodes) > m.Capacity() {
+		nodes = nodes[:m.Capacity()]
+	}
+	m.nodes = nodes
+	m.table = nil
+	if len(m.nodes) == 0 {
+		return
+	}
+
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	next := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := hash64(node)
+		offsets[i] = h % m.size
+		skips[i] = mix(h)%(m.size-1) + 1
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := uint64(0); ; {
+		for i := range m.nodes {
+			slot := (offsets[i] + next[i]*skips[i]) % m.size
+			for table[slot] >= 0 {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % m.size
+			}
+			table[slot] = i
+			next[i]++
+			filled++
+			if filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+// Clone shares the lookup table, which SetNodes replaces rather than modifies.
+func (m *Maglev) String() string {
+	return fmt.Sprintf("maglev/%d", m.size)
+}
+
+func (m *Maglev) Clone() Placement {
+	clone := *m
+	return &clone
+}
+
+func (m *Maglev) Owner(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[hash64(key)%m.size]]
+}
+
+// Replicas walks the table from the key's slot collecting distinct nodes.
+func (m *Maglev) Replicas(key string, n int) []string {
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	replicas := make([]string, 0, n)
+	seen := make(map[int]bool, n)
+	for slot := hash64(key) % m.size; len(replicas) < n; slot = (slot + 1) % m.size {
+		if idx := m.table[slot]; !seen[idx] {
+			seen[idx] = true
+			replicas = append(replicas, m.nodes[idx])
+		}
+	}
+	return replicas
+}
@@ -0,0 +1,75 @@
+package placement
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Placement decides which nodes own a key. Implementations must be
+// deterministic so that every node computes the same owners from the same
+// membership.
+type Placement interface {
+	// Owner returns the primary node for key, or "" with no nodes.
+	Owner(key string) string
+	// Replicas returns up to n distinct nodes for key, owner first.
+	Replicas(key string, n int) []string
+	// SetNodes replaces the membership.
+	SetNodes(nodes ...string)
+	// Clone returns an independent copy, so a caller can apply changes to
+	// the copy while others keep reading the original.
+	Clone() Placement
+}
+
+// Describe returns p's algorithm and parameters, the same on nodes that
+// place keys the same way. Placements with parameters describe themselves by
+// implementing fmt.Stringer; others are described by their type.
+func Describe(p Placement) string {
+	if s, ok := p.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// Capacity returns the most nodes p places keys on, or 0 if it has no limit.
+// Placements with a limit implement Capacity themselves.
+func Capacity(p Placement) int {
+	if t, ok := p.(*TopologyAware); ok {
+		return Capacity(t.base)
+	}
+	if c, ok := p.(interface{ Capacity() int }); ok {
+		return c.Capacity()
+	}
+	return 0
+}
+
+// hash64 is the 64-bit key hash shared by the implementations in this package.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return mix(h.Sum64())
+}
+
+// mix is the splitmix64 finalizer; it spreads FNV's weak low bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// sortedUnique returns the distinct nodes in sorted order, so every member
+// builds the same structure regardless of the order it learned them in.
+func sortedUnique(nodes []string) []string {
+	out := append([]string(nil), nodes...)
+	sort.Strings(out)
+	uniq := out[:0]
+	for i, node := range out {
+		if i == 0 || node != out[i-1] {
+			uniq = append(uniq, node)
+		}
+	}
+	return uniq
+}
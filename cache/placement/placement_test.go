@@ -0,0 +1,218 @@
+package placement_test
+
+import (
+	"distributed-cache/cache/consistenthash"
+	"distributed-cache/cache/placement"
+	"fmt"
+	"runtime"
+	"slices"
+	"sort"
+	"testing"
+)
+
+var algorithms = map[string]func() placement.Placement{
+	"ring":       func() placement.Placement { return placement.NewRing(consistenthash.NewMap(100, nil)) },
+	"rendezvous": func() placement.Placement { return placement.NewRendezvous() },
+	"jump":       func() placement.Placement { return placement.NewJump() },
+	"maglev":     func() placement.Placement { return placement.NewMaglev(0) },
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%02d", i)
+	}
+	return nodes
+}
+
+// imbalance returns the largest node's key count over the average.
+func imbalance(p placement.Placement, nodes []string, keys int) float64 {
+	counts := make(map[string]int, len(nodes))
+	for i := 0; i < keys; i++ {
+		counts[p.Owner(fmt.Sprintf("key-%d", i))]++
+	}
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	return float64(max) * float64(len(nodes)) / float64(keys)
+}
+
+// moved returns the fraction of keys whose owner changes when a node is
+// added at the end of the sorted membership.
+func moved(p placement.Placement, nodes []string, keys int) float64 {
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = p.Owner(fmt.Sprintf("key-%d", i))
+	}
+	p.SetNodes(append(append([]string(nil), nodes...), "node-zz")...)
+	n := 0
+	for i := range before {
+		if p.Owner(fmt.Sprintf("key-%d", i)) != before[i] {
+			n++
+		}
+	}
+	p.SetNodes(nodes...)
+	return float64(n) / float64(keys)
+}
+
+func TestPlacements(t *testing.T) {
+	nodes := nodeNames(10)
+	for name, build := range algorithms {
+		t.Run(name, func(t *testing.T) {
+			a, b := build(), build()
+			a.SetNodes(nodes...)
+			reversed := make([]string, len(nodes))
+			for i, node := range nodes {
+				reversed[len(nodes)-1-i] = node
+			}
+			b.SetNodes(reversed...)
+
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				if a.Owner(key) != b.Owner(key) {
+					t.Fatalf("owner of %s depends on membership order", key)
+				}
+				replicas := a.Replicas(key, 3)
+				if len(replicas) != 3 || replicas[0] != a.Owner(key) {
+					t.Fatalf("replicas of %s should start with the owner: %v", key, replicas)
+				}
+				if replicas[0] == replicas[1] || replicas[1] == replicas[2] || replicas[0] == replicas[2] {
+					t.Fatalf("replicas of %s are not distinct: %v", key, replicas)
+				}
+			}
+
+			if r := imbalance(a, nodes, 20000); r > 1.35 {
+				t.Fatalf("max/avg load %.2f is too high", r)
+			}
+			if f := moved(a, nodes, 20000); f > 0.15 {
+				t.Fatalf("adding an 11th node moved %.3f of keys", f)
+			}
+		})
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	for name, build := range algorithms {
+		p := build()
+		if p.Owner("key") != "" || len(p.Replicas("key", 3)) != 0 {
+			t.Fatalf("%s: empty placement should own nothing", name)
+		}
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	for _, size := range []uint64{1, 4, 100} {
+		if !panics(func() { placement.NewMaglev(size) }) {
+			t.Fatalf("table size %d is not prime and should be rejected", size)
+		}
+	}
+	m := placement.NewMaglev(5, nodeNames(4)...)
+	if got := m.Replicas("key", 4); len(got) != 4 {
+		t.Fatalf("expected all 4 nodes as replicas, got %v", got)
+	}
+
+	// Past its capacity the table keeps the first nodes in sorted order,
+	// the same on every member, instead of failing a membership change.
+	nodes := nodeNames(6)
+	m.SetNodes(nodes...)
+	if placement.Capacity(m) != 4 || placement.Capacity(placement.NewTopologyAware(m)) != 4 {
+		t.Fatalf("expected a capacity of 4, got %d", placement.Capacity(m))
+	}
+	sort.Strings(nodes)
+	got := m.Replicas("key", 6)
+	sort.Strings(got)
+	if !slices.Equal(got, nodes[:4]) {
+		t.Fatalf("expected the first 4 nodes to own keys, got %v", got)
+	}
+}
+
+func panics(f func()) (panicked bool) {
+	defer func() { panicked = recover() != nil }()
+	f()
+	return false
+}
+
+// BenchmarkPlacement compares lookup speed and reports, per algorithm, the
+// heap used by a 50-node membership, max/avg key balance and the fraction of
+// keys moved by adding a node.
+func BenchmarkPlacement(b *testing.B) {
+	nodes := nodeNames(50)
+	for _, name := range []string{"ring", "rendezvous", "jump", "maglev"} {
+		build := algorithms[name]
+		b.Run(name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			p := build()
+			p.SetNodes(nodes...)
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Owner(keys[i%len(keys)])
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "heap-bytes")
+			b.ReportMetric(imbalance(p, nodes, 50000), "max/avg")
+			b.ReportMetric(moved(p, nodes, 50000), "moved-frac")
+		})
+	}
+}
+
+func TestTopologyAware(t *testing.T) {
+	nodes := nodeNames(6)
+	build := func(order []string) *placement.TopologyAware {
+		p := placement.NewTopologyAware(placement.NewRendezvous())
+		p.SetNodes(order...)
+		for i, node := range nodes {
+			// Two nodes per zone, each on its own rack.
+			p.SetTopology(node, placement.Topology{
+				Zone: fmt.Sprintf("zone-%d", i/2),
+				Rack: fmt.Sprintf("rack-%d", i),
+			})
+		}
+		return p
+	}
+	a := build(nodes)
+	b := build([]string{nodes[5], nodes[3], nodes[1], nodes[4], nodes[2], nodes[0]})
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		replicas := a.Replicas(key, 3)
+		if replicas[0] != a.Owner(key) {
+			t.Fatalf("first replica of %s should be the owner", key)
+		}
+		zones := make(map[string]bool)
+		for _, node := range replicas {
+			zones[a.Topology(node).Zone] = true
+		}
+		if len(zones) != 3 {
+			t.Fatalf("replicas of %s share a zone: %v", key, replicas)
+		}
+		if fmt.Sprint(replicas) != fmt.Sprint(b.Replicas(key, 3)) {
+			t.Fatalf("replicas of %s depend on membership order", key)
+		}
+	}
+
+	// With more replicas than zones, the extras still spread evenly.
+	for _, key := range []string{"x", "y", "z"} {
+		zones := make(map[string]int)
+		for _, node := range a.Replicas(key, 6) {
+			zones[a.Topology(node).Zone]++
+		}
+		for zone, n := range zones {
+			if n != 2 {
+				t.Fatalf("zone %s holds %d of 6 replicas", zone, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package placement
+
+import "sort"
+
+// Rendezvous is highest random weight (HRW) hashing: a key belongs to the
+// node with the highest hash(node, key) score. Lookups are O(nodes), but
+// there is no ring to store and a membership change moves only the keys of
+// the node that changed.
+type Rendezvous struct {
+	nodes  []string
+	hashes []uint64 // per-node seeds, parallel to nodes
+}
+
+func NewRendezvous(nodes ...string) *Rendezvous {
+	r := &Rendezvous{}
+	r.SetNodes(nodes...)
+	return r
+}
+
+func (r *Rendezvous) SetNodes(nodes ...string) {
+	r.nodes = sortedUnique(nodes)
+	r.hashes = make([]uint64, len(r.nodes))
+	for i, node := range r.nodes {
+		r.hashes[i] = hash64(node)
+	}
+}
+
+// Clone shares the node slices, which SetNodes replaces rather than modifies.
+func (r *Rendezvous) Clone() Placement {
+	clone := *r
+	return &clone
+}
+
+func (r *Rendezvous) score(i int, keyHash uint64) uint64 {
+	return mix(keyHash ^ r.hashes[i])
+}
+
+func (r *Rendezvous) Owner(key string) string {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	keyHash := hash64(key)
+	best, bestScore := 0, r.score(0, keyHash)
+	for i := 1; i < len(r.nodes); i++ {
+		if s := r.score(i, keyHash); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return r.nodes[best]
+}
+
+func (r *Rendezvous) Replicas(key string, n int) []string {
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+	keyHash := hash64(key)
+	order := make([]int, len(r.nodes))
+	scores := make([]uint64, len(r.nodes))
+	for i := range r.nodes {
+		order[i] = i
+		scores[i] = r.score(i, keyHash)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	replicas := make([]string, n)
+	for i := range replicas {
+		replicas[i] = r.nodes[order[i]]
+	}
+	return replicas
+}
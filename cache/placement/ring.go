@@ -0,0 +1,35 @@
+package placement
+
+import (
+	"distributed-cache/cache/consistenthash"
+	"fmt"
+)
+
+// Ring places keys on a consistent hash ring. The ring package stays free of
+// this one; Ring only adapts its Clone to return a Placement.
+type Ring struct {
+	*consistenthash.Map
+	// HashName names the ring's key hash in its description; rings with an
+	// unnamed hash are described as hashing with "custom".
+	HashName string
+}
+
+// NewRing wraps m.
+func NewRing(m *consistenthash.Map) Ring {
+	return Ring{Map: m}
+}
+
+func (r Ring) Clone() Placement {
+	return Ring{Map: r.Map.Clone(), HashName: r.HashName}
+}
+
+func (r Ring) String() string {
+	name := r.HashName
+	if name == "" {
+		name = "custom"
+	}
+	return fmt.Sprintf("ring/v%d/%s/%d", consistenthash.FormatVersion, name, r.Replication())
+}
+
+// Compile time assertion
+var _ Placement = Ring{}
@@ -0,0 +1,129 @@
+package placement
+
+// Topology labels a node with its failure domains, from widest to narrowest.
+type Topology struct {
+	Zone string
+	Rack string
+	Host string
+}
+
+// TopologyAware spreads replicas across failure domains. The owner is the
+// wrapped placement's owner; each further replica is the node, in the wrapped
+// placement's preference order, whose zone, then rack, then host holds the
+// fewest replicas chosen so far. Because it only reorders the wrapped
+// placement's deterministic list, every node computes the same replica set.
+type TopologyAware struct {
+	base   Placement
+	nodes  int
+	labels map[string]Topology
+}
+
+func NewTopologyAware(base Placement) *TopologyAware {
+	return &TopologyAware{base: base, labels: make(map[string]Topology)}
+}
+
+// Base returns the wrapped placement.
+func (t *TopologyAware) Base() Placement {
+	return t.base
+}
+
+// SetTopology labels node. Unlabelled nodes share the empty zone, rack and
+// host.
+func (t *TopologyAware) SetTopology(node string, topo Topology) {
+	t.labels[node] = topo
+}
+
+func (t *TopologyAware) Topology(node string) Topology {
+	return t.labels[node]
+}
+
+func (t *TopologyAware) String() string {
+	return "topology/" + Describe(t.base)
+}
+
+func (t *TopologyAware) Clone() Placement {
+	clone := &TopologyAware{
+		base:   t.base.Clone(),
+		nodes:  t.nodes,
+		labels: make(map[string]Topology, len(t.labels)),
+	}
+	for node, topo := range t.labels {
+		clone.labels[node] = topo
+	}
+	return clone
+}
+
+func (t *TopologyAware) SetNodes(nodes ...string) {
+	nodes = sortedUnique(nodes)
+	t.nodes = len(nodes)
+	t.base.SetNodes(nodes...)
+	for node := range t.labels {
+		if !contains(nodes, node) {
+			delete(t.labels, node)
+		}
+	}
+}
+
+func (t *TopologyAware) Owner(key string) string {
+	return t.base.Owner(key)
+}
+
+func (t *TopologyAware) Replicas(key string, n int) []string {
+	if n > t.nodes {
+		n = t.nodes
+	}
+	if n <= 0 {
+		return nil
+	}
+	candidates := t.base.Replicas(key, t.nodes)
+	var (
+		replicas = make([]string, 0, n)
+		taken    = make([]bool, len(candidates))
+		zones    = make(map[string]int)
+		racks    = make(map[[2]string]int)
+		hosts    = make(map[[3]string]int)
+	)
+	for len(replicas) < n && len(replicas) < len(candidates) {
+		best := -1
+		var bestScore [3]int
+		for i, node := range candidates {
+			if taken[i] {
+				continue
+			}
+			topo := t.labels[node]
+			score := [3]int{
+				zones[topo.Zone],
+				racks[[2]string{topo.Zone, topo.Rack}],
+				hosts[[3]string{topo.Zone, topo.Rack, topo.Host}],
+			}
+			if best < 0 || less(score, bestScore) {
+				best, bestScore = i, score
+			}
+		}
+		taken[best] = true
+		topo := t.labels[candidates[best]]
+		zones[topo.Zone]++
+		racks[[2]string{topo.Zone, topo.Rack}]++
+		hosts[[3]string{topo.Zone, topo.Rack, topo.Host}]++
+		replicas = append(replicas, candidates[best])
+	}
+	return replicas
+}
+
+func less(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func contains(sorted []string, s string) bool {
+	for _, v := range sorted {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
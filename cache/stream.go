@@ -0,0 +1,368 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"distributed-cache/cache/breaker"
+	pb "distributed-cache/cache/pb"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// chunkSize is the unit large values are streamed and stored in.
+	chunkSize = 64 << 10
+
+	// streamHeader marks peer requests that accept a streamed reply.
+	streamHeader = "X-Dcache-Stream"
+	// streamContentType marks replies carrying the raw value, streamed.
+	streamContentType = "application/x-dcache-stream"
+	// streamLengthHeader carries the length of a streamed value, so a
+	// stream cut short is not taken for the whole value.
+	streamLengthHeader = "X-Dcache-Length"
+	// chunkEncodingHeader names the coding each chunk of a stream is
+	// compressed with. Compressed chunks are framed by their length, as a
+	// 4-byte big-endian prefix.
+	chunkEncodingHeader = "X-Dcache-Chunk-Encoding"
+	// maxFrame bounds a compressed chunk, which may be a little larger than
+	// the chunk when it does not compress.
+	maxFrame = 2 * chunkSize
+)
+
+// PeerStreamer is implemented by peer clients that can stream a value
+// instead of returning it in one message.
+type PeerStreamer interface {
+	GetStream(in *pb.GetRequest) (io.ReadCloser, error)
+}
+
+// GetReader is Get returning a reader over the value, so large values can be
+// streamed to clients without being copied into one slice.
+func (g *Group) GetReader(key string) (io.Reader, error) {
+	bv, err := g.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return bv.Reader(), nil
+}
+
+// readChunks reads r into a value, in chunks once it is larger than one,
+// failing if it is larger than limit bytes. Only io.EOF ends the value; any
+// other error, io.ErrUnexpectedEOF included, fails the read.
+func readChunks(r io.Reader, limit int64) (ByteView, error) {
+	// Most values are small, so read the first chunk with a buffer that
+	// grows as needed rather than a full chunk up front.
+	first, err := io.ReadAll(io.LimitReader(r, chunkSize))
+	if err != nil {
+		return ByteView{}, err
+	}
+	if int64(len(first)) > limit {
+		return ByteView{}, fmt.Errorf("data size exceeds cache size")
+	}
+	if len(first) < chunkSize {
+		return ByteView{bytes: first}, nil
+	}
+
+	chunks := [][]byte{first}
+	total := int64(len(first))
+	for {
+		buf := make([]byte, chunkSize)
+		n, err := readChunk(r, buf)
+		if n > 0 {
+			total += int64(n)
+			if total > limit {
+				return ByteView{}, fmt.Errorf("data size exceeds cache size")
+			}
+			chunks = append(chunks, buf[:n:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ByteView{}, err
+		}
+	}
+	if len(chunks) == 1 {
+		return ByteView{bytes: first}, nil
+	}
+	return ByteView{chunks: chunks}, nil
+}
+
+// readChunk fills buf from r. Unlike io.ReadFull it passes r's io.EOF
+// through, so the end of the value can be told from a truncated read.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// GetStream asks the peer for a value and returns a reader over it. Values
+// larger than a chunk arrive as a stream of chunks, compressed with the
+// negotiated coding if any; smaller ones in the usual message. A stream that
+// ends before the length the peer announced fails with io.ErrUnexpectedEOF.
+// The attempt timeout bounds the wait for the reply and then each wait for
+// more of a stream, so a long transfer that keeps making progress is not cut
+// off. The reader must be closed.
+func (h *HTTPGetter) GetStream(in *pb.GetRequest) (io.ReadCloser, error) {
+	h.inFlight.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	var timer *time.Timer
+	if h.attemptTimeout > 0 {
+		timer = time.AfterFunc(h.attemptTimeout, cancel)
+	}
+	done := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel()
+		h.inFlight.Add(-1)
+	}
+
+	u := fmt.Sprintf("%v%v/%v", h.baseURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+	req, err := h.newRequest(ctx, http.MethodGet, u, nil, "")
+	if err != nil {
+		done()
+		return nil, err
+	}
+	req.Header.Set(streamHeader, "1")
+	res, err := h.send(req)
+	if err != nil {
+		done()
+		if !errors.Is(err, breaker.ErrOpen) {
+			h.suspect(h.cooldown)
+		}
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		done()
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+	if res.Header.Get("Content-Type") == streamContentType {
+		r, err := newStreamReader(res.Body, res.Header)
+		if err != nil {
+			res.Body.Close()
+			done()
+			return nil, err
+		}
+		return &streamBody{Reader: r, body: res.Body, idle: timer, timeout: h.attemptTimeout, done: done}, nil
+	}
+
+	defer done()
+	defer res.Body.Close()
+	limit := payloadLimit(in.GetGroup())
+	body, err := readLimited(res.Body, limit)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if body, err = decodeBody(res.Header, body, limit); err != nil {
+		return nil, fmt.Errorf("decompressing response body: %v", err)
+	}
+	var out pb.Response
+	if err := proto.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("decoding response body: %v", err)
+	}
+	return io.NopCloser(bytes.NewReader(out.Value)), nil
+}
+
+// streamBody reads a stream, pushing back its idle timer on progress, and
+// releases the request's resources when it is closed.
+type streamBody struct {
+	io.Reader
+	body    io.Closer
+	idle    *time.Timer // nil without a timeout
+	timeout time.Duration
+	done    func()
+	once    sync.Once
+}
+
+func (s *streamBody) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if n > 0 && s.idle != nil {
+		s.idle.Reset(s.timeout)
+	}
+	return n, err
+}
+
+func (s *streamBody) Close() error {
+	err := s.body.Close()
+	s.once.Do(s.done)
+	return err
+}
+
+// newStreamReader returns a reader over the value in a stream body, checked
+// against its announced length and decompressed chunk by chunk if needed.
+func newStreamReader(body io.Reader, header http.Header) (io.Reader, error) {
+	length, err := strconv.ParseInt(header.Get(streamLengthHeader), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("stream without a valid %s", streamLengthHeader)
+	}
+	r := body
+	if coding := header.Get(chunkEncodingHeader); coding != "" {
+		c, ok := compressorFor(coding)
+		if !ok {
+			return nil, fmt.Errorf("unsupported chunk encoding %q", coding)
+		}
+		r = &chunkReader{r: body, c: c}
+	}
+	return &lengthReader{r: r, remaining: length}, nil
+}
+
+// lengthReader fails a stream that ends before, or runs past, its length.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	switch {
+	case l.remaining < 0:
+		return n, errors.New("stream longer than announced")
+	case err == io.EOF && l.remaining > 0:
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkReader reads a stream of length-prefixed compressed chunks.
+type chunkReader struct {
+	r   io.Reader
+	c   Compressor
+	buf []byte // rest of the current chunk
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		var prefix [4]byte
+		if _, err := io.ReadFull(cr.r, prefix[:]); err != nil {
+			return 0, err // io.EOF only between chunks
+		}
+		size := binary.BigEndian.Uint32(prefix[:])
+		if size > maxFrame {
+			return 0, fmt.Errorf("stream chunk of %d bytes is too large", size)
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(cr.r, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		data, err := cr.c.Decompress(frame, chunkSize)
+		if err != nil {
+			return 0, err
+		}
+		cr.buf = data
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+// setStreamHeaders marks h as carrying bv as a stream, its chunks compressed
+// with c if it is not nil.
+func setStreamHeaders(h http.Header, bv ByteView, c Compressor) {
+	h.Set("Content-Type", streamContentType)
+	h.Set(streamLengthHeader, strconv.Itoa(bv.Len()))
+	if c != nil {
+		h.Set(chunkEncodingHeader, c.Name())
+	}
+}
+
+// writeStream writes bv to w chunk by chunk, each compressed with c and
+// framed by its length if c is not nil, raw otherwise.
+func writeStream(w io.Writer, bv ByteView, c Compressor) error {
+	if c == nil {
+		_, err := io.Copy(w, bv.Reader())
+		return err
+	}
+	chunks := bv.chunked().chunks
+	if chunks == nil {
+		chunks = [][]byte{bv.bytes}
+	}
+	for _, chunk := range chunks {
+		data, err := c.Compress(chunk)
+		if err != nil {
+			return err
+		}
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], uint32(len(data)))
+		if _, err := w.Write(prefix[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStream sends a large value to the peer at u as a stream, so the peer
+// reads and keeps it in chunks.
+func (h *HTTPGetter) setStream(in *pb.SetRequest, u string) error {
+	bv := ByteView{bytes: in.Value}
+	var c Compressor
+	if len(h.codings) > 0 {
+		c, _ = compressorFor(h.codings[0])
+	}
+	var body bytes.Buffer
+	if err := writeStream(&body, bv, c); err != nil {
+		return err
+	}
+	req, err := h.newRequest(context.Background(), http.MethodPost, u, body.Bytes(), "")
+	if err != nil {
+		return err
+	}
+	setStreamHeaders(req.Header, bv, c)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST to %s failed: %w", u, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned status %s", u, res.Status)
+	}
+	return nil
+}
+
+// serveStreamedSet stores a value sent by setStream.
+func (p *HTTPPool) serveStreamedSet(w http.ResponseWriter, r *http.Request, groupName, key string, limit int64) {
+	group := GetGroup(groupName)
+	// Framing and incompressible chunks can make the body a little larger
+	// than the value.
+	r2, err := newStreamReader(http.MaxBytesReader(w, r.Body, 2*limit), r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view, err := readChunks(r2, group.cache.cacheSize)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.cache.Add(key, view)
+	w.WriteHeader(http.StatusOK)
+}
+
+var _ PeerStreamer = (*HTTPGetter)(nil)
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"bytes"
+	pb "distributed-cache/cache/pb"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReadChunks(t *testing.T) {
+	small, err := readChunks(strings.NewReader("small"), 1<<20)
+	if err != nil || small.chunks != nil || small.String() != "small" {
+		t.Fatalf("small value should be read whole, got %d chunks, %v", len(small.chunks), err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), chunkSize/4) // 2.5 chunks
+	large, err := readChunks(bytes.NewReader(data), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(large.chunks) != 3 || large.Len() != len(data) {
+		t.Fatalf("expected 3 chunks of %d bytes, got %d of %d", len(data), len(large.chunks), large.Len())
+	}
+	read, _ := io.ReadAll(large.Reader())
+	if !bytes.Equal(read, data) || !bytes.Equal(large.Bytes(), data) {
+		t.Fatal("chunked value does not read back")
+	}
+
+	if _, err := readChunks(bytes.NewReader(data), int64(len(data)-1)); err == nil {
+		t.Fatal("expected an error for a value over the limit")
+	}
+
+	cut := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := readChunks(cut, 1<<20); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("a truncated read should fail, got %v", err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 2*chunkSize)
+	for name, header := range map[string]string{
+		// The body ends in the middle of a chunk of the transfer coding.
+		"chunked": "Transfer-Encoding: chunked\r\n\r\n" + fmt.Sprintf("%x\r\n", len(data)),
+		// Without a transfer coding only the announced length shows the
+		// stream was cut.
+		"close": "Connection: close\r\n\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: %s\r\n%s: %d\r\n%s",
+					streamContentType, streamLengthHeader, len(data), header)
+				buf.Write(data[:chunkSize+chunkSize/2])
+				buf.Flush()
+			}))
+			defer srv.Close()
+			pool := NewHTTPPool("http://self")
+			defer pool.Close()
+			pool.Set("http://self", srv.URL)
+			peer := pool.state.Load().httpGetters[srv.URL]
+
+			r, err := peer.GetStream(&pb.GetRequest{Group: "g", Key: "k"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := readChunks(r, 1<<20); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("expected io.ErrUnexpectedEOF for a cut stream, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamedPeerLoad(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		types []string
+	)
+	pools := startPools(t, 2, WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := base.RoundTrip(r)
+			if err == nil && !isAdmin(r) {
+				mu.Lock()
+				types = append(types, res.Header.Get("Content-Type"))
+				mu.Unlock()
+			}
+			return res, err
+		})
+	}))
+	large := bytes.Repeat([]byte("x"), 3*chunkSize+1)
+	group := NewGroup("streamed", 8*chunkSize, GetterFunc(func(key string) ([]byte, error) {
+		if key == "large" {
+			return large, nil
+		}
+		return []byte(key), nil
+	}))
+	peer := pools[0].state.Load().httpGetters[pools[1].self]
+
+	view, err := group.peerLoad(peer, "large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(view.chunks) != 4 || !bytes.Equal(view.Bytes(), large) {
+		t.Fatalf("expected the value in 4 chunks, got %d", len(view.chunks))
+	}
+	if view, err = group.peerLoad(peer, "small"); err != nil || view.String() != "small" {
+		t.Fatalf("small peer load failed: %v", err)
+	}
+
+	mu.Lock()
+	if len(types) != 2 || types[0] != streamContentType || types[1] == streamContentType {
+		t.Fatalf("only the large value should be streamed, got %v", types)
+	}
+	mu.Unlock()
+	if peer.InFlight() != 0 {
+		t.Fatalf("in-flight count leaked: %d", peer.InFlight())
+	}
+
+	// Plain Get still receives whole messages.
+	var res pb.Response
+	if err := peer.Get(&pb.GetRequest{Group: "streamed", Key: "large"}, &res); err != nil || !bytes.Equal(res.Value, large) {
+		t.Fatalf("plain get of a large value failed: %v", err)
+	}
+}
+
+func TestGetReader(t *testing.T) {
+	group := NewGroup("reader", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value-" + key), nil
+	}))
+	r, err := group.GetReader("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "value-k" {
+		t.Fatalf("got %q", data)
+	}
+}
+
+func TestLargeValuesStoredInChunks(t *testing.T) {
+	c := NewCache(8*chunkSize, nil)
+	large := bytes.Repeat([]byte("x"), 2*chunkSize+1)
+	c.Add("large", ByteView{bytes: large})
+	view, ok := c.Get("large")
+	if !ok || len(view.chunks) != 3 || !bytes.Equal(view.Bytes(), large) {
+		t.Fatalf("expected the value held in 3 chunks, got %d", len(view.chunks))
+	}
+}
+
+func TestCompressedStreams(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		encoding = make(map[string]string) // method -> chunk coding seen on the wire
+	)
+	pools := startPools(t, 2, WithCompression(0), WithRoundTripper(func(base http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			res, err := base.RoundTrip(r)
+			if err == nil && !isAdmin(r) {
+				mu.Lock()
+				if r.Method == http.MethodPost {
+					encoding[r.Method] = r.Header.Get(chunkEncodingHeader)
+				} else {
+					encoding[r.Method] = res.Header.Get(chunkEncodingHeader)
+				}
+				mu.Unlock()
+			}
+			return res, err
+		})
+	}))
+	large := []byte(strings.Repeat("large json blob ", 3*chunkSize/16))
+	group := NewGroup("compressed-streams", 8*chunkSize, GetterFunc(func(key string) ([]byte, error) {
+		return large, nil
+	}))
+	peer := pools[0].state.Load().httpGetters[pools[1].self]
+
+	view, err := group.peerLoad(peer, "k")
+	if err != nil || len(view.chunks) != 3 || !bytes.Equal(view.Bytes(), large) {
+		t.Fatalf("compressed stream did not read back: %v", err)
+	}
+	if err := peer.Set(&pb.SetRequest{Group: "compressed-streams", Key: "pushed", Value: large}, &pb.EmptyResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := group.cache.Get("pushed"); !ok || len(view.chunks) != 3 || !bytes.Equal(view.Bytes(), large) {
+		t.Fatal("a large replica write should be stored in chunks")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if encoding[http.MethodGet] != Gzip.Name() || encoding[http.MethodPost] != Gzip.Name() {
+		t.Fatalf("large values should be streamed compressed, got %v", encoding)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	const gap = 40 * time.Millisecond
+	data := bytes.Repeat([]byte("x"), 4*chunkSize)
+	var stall atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setStreamHeaders(w.Header(), ByteView{bytes: data}, nil)
+		for i := 0; i < 4; i++ {
+			w.Write(data[i*chunkSize : (i+1)*chunkSize])
+			w.(http.Flusher).Flush()
+			if stall.Load() && i == 1 {
+				time.Sleep(4 * gap)
+			} else {
+				time.Sleep(gap)
+			}
+		}
+	}))
+	defer srv.Close()
+	pool := NewHTTPPool("http://self", WithFailover(2*gap, time.Minute))
+	defer pool.Close()
+	pool.Set("http://self", srv.URL)
+	peer := pool.state.Load().httpGetters[srv.URL]
+	read := func() error {
+		r, err := peer.GetStream(&pb.GetRequest{Group: "g", Key: "k"})
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = readChunks(r, 1<<20)
+		return err
+	}
+
+	// The transfer takes longer than the attempt timeout but keeps going.
+	if err := read(); err != nil {
+		t.Fatalf("a stream making progress should not time out: %v", err)
+	}
+	stall.Store(true)
+	if err := read(); err == nil {
+		t.Fatal("a stalled stream should time out")
+	}
+}
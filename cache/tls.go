@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSConfig sets up TLS between peers. With RequireClientCert, peers must
+// authenticate each other (mutual TLS).
+type TLSConfig struct {
+	CertFile string // this node's certificate chain, PEM
+	KeyFile  string // its private key, PEM
+	CAFile   string // CA bundle peer certificates must chain to; empty uses the system roots
+
+	// RequireClientCert makes the listener reject peers without a valid
+	// client certificate.
+	RequireClientCert bool
+	// AllowedIdentities, if set, limits accepted peer certificates to those
+	// with one of these DNS names, URIs or common names.
+	AllowedIdentities []string
+}
+
+// certCheckInterval is how often handshakes look for changed cert files.
+const certCheckInterval = time.Second
+
+// PeerTLS serves TLS configurations for the peer listener and client. The
+// certificate and CA bundle are reloaded when their files change, so they
+// can be rotated without a restart.
+type PeerTLS struct {
+	cfg     TLSConfig
+	allowed map[string]bool
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	roots     *x509.CertPool // nil for the system roots
+	modTimes  [3]time.Time   // of CertFile, KeyFile and CAFile when loaded
+	failed    [3]time.Time   // mod times of files that last failed to load
+	checkedAt time.Time
+}
+
+func NewPeerTLS(cfg TLSConfig) (*PeerTLS, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls: CertFile and KeyFile are required")
+	}
+	t := &PeerTLS{cfg: cfg}
+	if len(cfg.AllowedIdentities) > 0 {
+		t.allowed = make(map[string]bool, len(cfg.AllowedIdentities))
+		for _, id := range cfg.AllowedIdentities {
+			t.allowed[id] = true
+		}
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the certificate, key and CA bundle again. On error the
+// previous ones stay in use.
+func (t *PeerTLS) Reload() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reload()
+}
+
+// reload loads the files. t.mu must be held.
+func (t *PeerTLS) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: loading key pair: %w", err)
+	}
+	var roots *x509.CertPool
+	if t.cfg.CAFile != "" {
+		pem, err := os.ReadFile(t.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("tls: reading CA bundle: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates in %s", t.cfg.CAFile)
+		}
+	}
+	t.cert, t.roots = &cert, roots
+	t.modTimes = t.statFiles()
+	t.checkedAt = time.Now()
+	return nil
+}
+
+func (t *PeerTLS) statFiles() [3]time.Time {
+	var times [3]time.Time
+	for i, name := range []string{t.cfg.CertFile, t.cfg.KeyFile, t.cfg.CAFile} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			times[i] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// current returns the certificate and roots, reloading them first if the
+// files changed since the last check.
+func (t *PeerTLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.checkedAt) >= certCheckInterval {
+		t.checkedAt = time.Now()
+		// Keep serving the old files until the new ones load. Files that
+		// failed are not retried until they change again.
+		if times := t.statFiles(); times != t.modTimes && times != t.failed {
+			if err := t.reload(); err != nil {
+				t.failed = times
+				tlsReloadFailures.Inc()
+				log.Printf("[tls] keeping the previous certificates: %v", err)
+			}
+		}
+	}
+	return t.cert, t.roots
+}
+
+// ServerConfig returns the configuration for the peer listener, e.g.
+// http.Server{TLSConfig: t.ServerConfig()}.ListenAndServeTLS("", "").
+func (t *PeerTLS) ServerConfig() *tls.Config {
+	clientAuth := tls.RequestClientCert
+	if t.cfg.RequireClientCert {
+		clientAuth = tls.RequireAnyClientCert
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+		// Client certificates are checked against the current CA bundle
+		// here rather than through ClientCAs, which cannot be reloaded.
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil // only possible without RequireClientCert
+			}
+			return t.verify(cs, "", x509.ExtKeyUsageClientAuth)
+		},
+	}
+}
+
+// ClientConfig returns the configuration for calls to host, the DNS name or
+// IP address the peer is dialled by, which its certificate must carry. It is
+// used by WithTLS and WithGRPCTLS with the host of each peer address.
+func (t *PeerTLS) ClientConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+		// The server certificate is verified in VerifyConnection against
+		// the current CA bundle, host included, instead of by the default
+		// verifier, which cannot pick up a reloaded bundle. The host is
+		// passed in because the connection state leaves out IP addresses.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if host == "" {
+				return errors.New("tls: no peer host to verify")
+			}
+			return t.verify(cs, host, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// dialTLS returns a DialTLSContext for tr that checks each peer against the
+// host in its address.
+func (t *PeerTLS) dialTLS(tr *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := tr.DialContext
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := t.ClientConfig(host)
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+		tlsConn := tls.Client(conn, cfg)
+		if tr.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tr.TLSHandshakeTimeout)
+			defer cancel()
+		}
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// grpcCredentials are gRPC transport credentials that check each peer
+// against the host it is dialled by.
+type grpcCredentials struct {
+	credentials.TransportCredentials // serves the handshake's server side
+	t                                *PeerTLS
+}
+
+func (c grpcCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host, _, err := net.SplitHostPort(authority)
+	if err != nil {
+		host = authority
+	}
+	return credentials.NewTLS(c.t.ClientConfig(host)).ClientHandshake(ctx, authority, conn)
+}
+
+func (c grpcCredentials) Clone() credentials.TransportCredentials {
+	return grpcCredentials{TransportCredentials: c.TransportCredentials.Clone(), t: c.t}
+}
+
+// verify checks a peer's certificate chain and identity.
+func (t *PeerTLS) verify(cs tls.ConnectionState, host string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: peer sent no certificate")
+	}
+	_, roots := t.current()
+	leaf := cs.PeerCertificates[0]
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("tls: verifying peer certificate: %w", err)
+	}
+	if t.allowed != nil && !t.allows(leaf) {
+		return fmt.Errorf("tls: peer identity %q is not allowed", leaf.Subject.CommonName)
+	}
+	return nil
+}
+
+func (t *PeerTLS) allows(cert *x509.Certificate) bool {
+	if t.allowed[cert.Subject.CommonName] {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if t.allowed[name] {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if t.allowed[uri.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTLS makes the pool call peers over TLS with t's client certificate.
+// Peer addresses must then use https://, and the listener should be served
+// with t.ServerConfig().
+func WithTLS(t *PeerTLS) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.tls = t
+	}
+}
+
+// WithGRPCTLS makes the pool call peers over TLS with t's client certificate,
+// and NewServer serve with t.ServerConfig().
+func WithGRPCTLS(t *PeerTLS) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.tls = t
+		p.dialOpts = append(p.dialOpts, grpc.WithTransportCredentials(grpcCredentials{
+			TransportCredentials: credentials.NewTLS(t.ServerConfig()),
+			t:                    t,
+		}))
+	}
+}
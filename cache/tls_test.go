@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	pb "distributed-cache/cache/pb"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCA issues certificates for peers in tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, filepath.Join(ca.dir, "ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a cert and key for name, valid for 127.0.0.1, and returns
+// the TLS config using them.
+func (ca *testCA) issue(t *testing.T, name string) TLSConfig {
+	t.Helper()
+	return ca.issueFor(t, name, net.ParseIP("127.0.0.1"))
+}
+
+// issueFor is issue with the certificate valid for ips instead.
+func (ca *testCA) issueFor(t *testing.T, name string, ips ...net.IP) TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := TLSConfig{
+		CertFile: filepath.Join(ca.dir, name+".pem"),
+		KeyFile:  filepath.Join(ca.dir, name+"-key.pem"),
+		CAFile:   filepath.Join(ca.dir, "ca.pem"),
+	}
+	writePEM(t, cfg.CertFile, "CERTIFICATE", der)
+	writePEM(t, cfg.KeyFile, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSPeer serves a pool over TLS with the given config and returns
+// its address.
+func startTLSPeer(t *testing.T, cfg TLSConfig) string {
+	t.Helper()
+	peerTLS, err := NewPeerTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(nil)
+	pool := NewHTTPPool("https://"+srv.Listener.Addr().String(), WithTLS(peerTLS))
+	srv.Config.Handler = pool
+	// Not StartTLS: it would install its own certificate.
+	srv.Listener = tls.NewListener(srv.Listener, peerTLS.ServerConfig())
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return pool.self
+}
+
+// tlsClient returns a getter calling addr with the given config.
+func tlsClient(t *testing.T, cfg TLSConfig, addr string) *HTTPGetter {
+	t.Helper()
+	peerTLS, err := NewPeerTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewHTTPPool("https://client", WithTLS(peerTLS))
+	pool.Set(addr)
+	return pool.state.Load().httpGetters[addr]
+}
+
+func TestMutualTLS(t *testing.T) {
+	NewGroup("tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	ca := newTestCA(t)
+	serverCfg := ca.issue(t, "node-a")
+	serverCfg.RequireClientCert = true
+	serverCfg.AllowedIdentities = []string{"node-b"}
+	addr := startTLSPeer(t, serverCfg)
+	req := &pb.GetRequest{Group: "tls", Key: "k"}
+
+	var res pb.Response
+	if err := tlsClient(t, ca.issue(t, "node-b"), addr).Get(req, &res); err != nil || string(res.Value) != "k" {
+		t.Fatalf("allowed peer should be served, got %q, %v", res.Value, err)
+	}
+	if err := tlsClient(t, ca.issue(t, "node-c"), addr).Get(req, &res); err == nil {
+		t.Fatal("peer with an identity not allowed should be rejected")
+	}
+
+	other := newTestCA(t)
+	if err := tlsClient(t, other.issue(t, "node-b"), addr).Get(req, &res); err == nil {
+		t.Fatal("peer with a certificate from another CA should be rejected")
+	}
+}
+
+func TestPeerTLSChecksAddress(t *testing.T) {
+	NewGroup("tls-address", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	ca := newTestCA(t)
+	// Served at 127.0.0.1 with a certificate for another node's address.
+	addr := startTLSPeer(t, ca.issueFor(t, "node-a", net.ParseIP("10.9.9.9")))
+
+	var res pb.Response
+	err := tlsClient(t, ca.issue(t, "node-b"), addr).Get(&pb.GetRequest{Group: "tls-address", Key: "k"}, &res)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1") {
+		t.Fatalf("a certificate for another address should be rejected, got %v", err)
+	}
+}
+
+func TestPeerTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := ca.issue(t, "node-a")
+	peerTLS, err := NewPeerTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := peerTLS.current()
+
+	// Rotate the files in place, as a cert manager would.
+	rotated := ca.issue(t, "node-a-rotated")
+	for from, to := range map[string]string{rotated.CertFile: cfg.CertFile, rotated.KeyFile: cfg.KeyFile} {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(to, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := peerTLS.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := peerTLS.current()
+	leaf, err := x509.ParseCertificate(after.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || leaf.Subject.CommonName != "node-a-rotated" {
+		t.Fatalf("expected the rotated certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	// A broken file keeps the last good certificate.
+	os.WriteFile(cfg.KeyFile, []byte("garbage"), 0o600)
+	if err := peerTLS.Reload(); err == nil || !strings.Contains(err.Error(), "key pair") {
+		t.Fatalf("expected a key pair error, got %v", err)
+	}
+	if cert, _ := peerTLS.current(); cert != after {
+		t.Fatal("failed reload should keep the previous certificate")
+	}
+}
+
+func TestPeerTLSReloadFailureReported(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := ca.issue(t, "node-a")
+	peerTLS, err := NewPeerTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, _ := peerTLS.current()
+	before := testutil.ToFloat64(tlsReloadFailures)
+
+	os.WriteFile(cfg.KeyFile, []byte("garbage"), 0o600)
+	os.Chtimes(cfg.KeyFile, time.Now(), time.Now().Add(time.Minute))
+	for i := 0; i < 3; i++ {
+		peerTLS.checkedAt = time.Time{}
+		if cert, _ := peerTLS.current(); cert != good {
+			t.Fatal("failed rotation should keep the previous certificate")
+		}
+	}
+	if got := testutil.ToFloat64(tlsReloadFailures) - before; got != 1 {
+		t.Fatalf("a broken rotation should be reported once until the files change, got %v", got)
+	}
+}
+
+func TestGRPCTLS(t *testing.T) {
+	NewGroup("grpc-tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	// Peers dial "nodea" and "nodeb"; only nodea is served with a
+	// certificate for its name, so only calls to it are checked.
+	ca := newTestCA(t)
+	peerTLS, err := NewPeerTLS(ca.issue(t, "nodea"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := startGRPCPools(t, 2, WithGRPCTLS(peerTLS))
+	client := pools[1].state.Load().clients[pools[0].self]
+
+	var res pb.Response
+	if err := client.Get(&pb.GetRequest{Group: "grpc-tls", Key: "k"}, &res); err != nil || string(res.Value) != "k" {
+		t.Fatalf("TLS call failed: %q, %v", res.Value, err)
+	}
+
+	// The same dialer without the TLS credentials cannot talk to the server.
+	opts := pools[1].dialOpts
+	plain := NewGRPCPool("plain", WithDialOptions(opts[:len(opts)-1]...), WithRPCTimeout(200*time.Millisecond))
+	defer plain.Close()
+	plain.Set(pools[0].self)
+	if err := plain.state.Load().clients[pools[0].self].Get(&pb.GetRequest{Group: "grpc-tls", Key: "k"}, &res); err == nil {
+		t.Fatal("plaintext call to a TLS server should fail")
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"bytes"
+	lru "distributed-cache/cache/lru_cache"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec converts between domain values and the bytes stored in a Group.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec handles generated message pointers such as *pb.Response.
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Decode(data []byte) (T, error) {
+	var zero T
+	v := zero.ProtoReflect().New().Interface().(T)
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
+
+// TypedGroup is a Group that stores values of type T through a Codec.
+type TypedGroup[T any] struct {
+	group   *Group
+	codec   Codec[T]
+	objects *objectCache[T] // nil when the object cache is disabled
+}
+
+// NewTypedGroup wraps group with codec. When objectCacheBytes is positive,
+// decoded values of up to that many encoded bytes are kept so hot reads skip
+// decoding. Cached values are shared between callers and must not be
+// modified. Groups with WithCompressedValues return fresh bytes on every
+// read, which the object cache cannot match, so it is not used for them.
+func NewTypedGroup[T any](group *Group, codec Codec[T], objectCacheBytes int64) *TypedGroup[T] {
+	if group == nil {
+		panic("Group cannot be nil")
+	}
+	if codec == nil {
+		panic("Codec cannot be nil")
+	}
+	tg := &TypedGroup[T]{group: group, codec: codec}
+	if objectCacheBytes > 0 && group.cache.compressor == nil {
+		tg.objects = &objectCache[T]{lruCache: lru.New(objectCacheBytes, nil)}
+	}
+	return tg
+}
+
+func (tg *TypedGroup[T]) Group() *Group {
+	return tg.group
+}
+
+func (tg *TypedGroup[T]) Get(key string) (T, error) {
+	bv, err := tg.group.Get(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if tg.objects != nil {
+		if v, ok := tg.objects.get(key, bv); ok {
+			return v, nil
+		}
+	}
+	v, err := tg.codec.Decode(bv.flat())
+	if err != nil {
+		return v, err
+	}
+	if tg.objects != nil {
+		tg.objects.add(key, bv, v)
+	}
+	return v, nil
+}
+
+// Set encodes v and writes it through Group.Set.
+func (tg *TypedGroup[T]) Set(key string, v T) error {
+	data, err := tg.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return tg.group.Set(key, data)
+}
+
+// objectCache keeps decoded values alongside the bytes they came from, so a
+// value is reused only while the Group still returns those same bytes.
+type objectCache[T any] struct {
+	mu       sync.Mutex
+	lruCache *lru.LRUCache
+}
+
+type decoded[T any] struct {
+	src   []byte // the value's bytes, or its first chunk
+	n     int    // length of the whole value
+	value T
+}
+
+func (d *decoded[T]) Len() int {
+	return d.n
+}
+
+// source returns the slice that identifies bv's bytes: the value or its
+// first chunk.
+func source(bv ByteView) []byte {
+	if bv.chunks != nil {
+		return bv.chunks[0]
+	}
+	return bv.bytes
+}
+
+func (oc *objectCache[T]) get(key string, bv ByteView) (value T, ok bool) {
+	src := source(bv)
+	if len(src) == 0 {
+		return
+	}
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	v, ok := oc.lruCache.Get(key)
+	if !ok {
+		return
+	}
+	d := v.(*decoded[T])
+	if &d.src[0] != &src[0] || len(d.src) != len(src) || d.n != bv.Len() {
+		return value, false
+	}
+	return d.value, true
+}
+
+func (oc *objectCache[T]) add(key string, bv ByteView, value T) {
+	src := source(bv)
+	if len(src) == 0 {
+		return
+	}
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.lruCache.Add(key, &decoded[T]{src: src, n: bv.Len(), value: value})
+}
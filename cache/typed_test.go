@@ -0,0 +1,68 @@
+package cache
+
+import (
+	pb "distributed-cache/cache/pb"
+	"encoding/json"
+	"testing"
+)
+
+type score struct {
+	Name  string
+	Score int
+}
+
+func TestTypedGroupCodecs(t *testing.T) {
+	s := score{Name: "Tom", Score: 631}
+
+	for name, codec := range map[string]Codec[score]{
+		"json": JSONCodec[score]{},
+		"gob":  GobCodec[score]{},
+	} {
+		data, err := codec.Encode(s)
+		if err != nil {
+			t.Fatalf("%s encode: %v", name, err)
+		}
+		if got, err := codec.Decode(data); err != nil || got != s {
+			t.Fatalf("%s round trip: got %+v, %v", name, got, err)
+		}
+	}
+
+	var pc ProtoCodec[*pb.GetRequest]
+	data, _ := pc.Encode(&pb.GetRequest{Group: "scores", Key: "Tom"})
+	if got, err := pc.Decode(data); err != nil || got.GetKey() != "Tom" {
+		t.Fatalf("proto round trip: got %v, %v", got, err)
+	}
+}
+
+func TestTypedGroupObjectCache(t *testing.T) {
+	decodes := 0
+	codec := countingCodec{decodes: &decodes}
+	group := NewGroup("typed", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return json.Marshal(score{Name: key, Score: 1})
+	}))
+	tg := NewTypedGroup[score](group, codec, 1<<10)
+
+	for i := 0; i < 3; i++ {
+		if v, err := tg.Get("Tom"); err != nil || v.Name != "Tom" {
+			t.Fatalf("typed get failed: %+v %v", v, err)
+		}
+	}
+	if decodes != 1 {
+		t.Fatalf("hot reads should reuse the decoded value, decodes = %d", decodes)
+	}
+
+	group.cache.Add("Tom", ByteView{bytes: []byte(`{"Name":"Tom","Score":2}`)})
+	if v, _ := tg.Get("Tom"); v.Score != 2 || decodes != 2 {
+		t.Fatalf("object cache served an outdated value: %+v", v)
+	}
+}
+
+type countingCodec struct {
+	JSONCodec[score]
+	decodes *int
+}
+
+func (c countingCodec) Decode(data []byte) (score, error) {
+	*c.decodes++
+	return c.JSONCodec.Decode(data)
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	pb "distributed-cache/cache/pb"
+	"fmt"
+)
+
+type Setter interface {
+	// Set writes the value for a key to the origin.
+	Set(key string, value []byte) error
+}
+
+type Deleter interface {
+	// Delete removes a key from the origin.
+	Delete(key string) error
+}
+
+// BatchSetter is implemented by origins that can take many writes in one
+// call. A WriteBehindQueue whose setter implements it sends each flush's sets
+// through SetBatch.
+type BatchSetter interface {
+	// SetBatch writes the values for several keys to the origin. An error
+	// fails every write in the batch.
+	SetBatch(values map[string][]byte) error
+}
+
+// WithWriteThrough makes Group.Set write to the origin first and only then
+// update the local cache and the key's replicas. If s also implements
+// Deleter, Group.Delete is enabled the same way.
+func WithWriteThrough(s Setter) GroupOption {
+	return func(g *Group) {
+		g.setter = s
+		g.deleter, _ = s.(Deleter)
+	}
+}
+
+// WithWriteBehind makes Group.Set update the cache at once and hand the
+// write to q, which flushes it to the origin in the background.
+func WithWriteBehind(q *WriteBehindQueue) GroupOption {
+	return func(g *Group) {
+		g.writeQueue = q
+	}
+}
+
+// Set writes value for key to the origin and the cache.
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if len(value) > int(g.cache.cacheSize) {
+		return fmt.Errorf("data size exceeds cache size")
+	}
+	data := ByteView{bytes: value}.cloneBytes()
+
+	switch {
+	case g.writeQueue != nil:
+		if err := g.writeQueue.enqueue(opSet, key, data); err != nil {
+			return err
+		}
+	case g.setter != nil:
+		if err := g.setter.Set(key, data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("no setter defined for group %s", g.name)
+	}
+
+	g.cache.Add(key, ByteView{bytes: data})
+	g.replicate(key, data)
+	return nil
+}
+
+// Delete removes key from the origin, the local cache and the key's replicas.
+// It fails without touching the origin if the group's peers cannot delete.
+func (g *Group) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	if dc, ok := g.peers.(deleteChecker); ok {
+		if err := dc.checkDelete(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case g.writeQueue != nil && g.writeQueue.deleter != nil:
+		if err := g.writeQueue.enqueue(opDelete, key, nil); err != nil {
+			return err
+		}
+	case g.writeQueue == nil && g.deleter != nil:
+		if err := g.deleter.Delete(key); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("no deleter defined for group %s", g.name)
+	}
+
+	g.cache.Remove(key)
+	g.replicateDelete(key)
+	return nil
+}
+
+// replicateDelete drops key from its replicas in the background.
+func (g *Group) replicateDelete(key string) {
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		go func() {
+			req := &pb.GetRequest{Group: g.name, Key: key}
+			var empty pb.EmptyResponse
+			for _, peer := range rp.ReplicaPeers(key, g.replicationFactor) {
+				_ = peer.Delete(req, &empty)
+			}
+		}()
+	}
+}
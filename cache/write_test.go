@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memStore struct {
+	mu   sync.Mutex
+	data map[string]string
+	fail bool
+}
+
+func (s *memStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%s not exist", key)
+}
+
+func (s *memStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return fmt.Errorf("origin down")
+	}
+	s.data[key] = string(value)
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return fmt.Errorf("origin down")
+	}
+	delete(s.data, key)
+	return nil
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := &memStore{data: map[string]string{}}
+	group := NewGroup("write-through", 2<<10, store, WithWriteThrough(store))
+
+	if err := group.Set("Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if store.data["Tom"] != "700" {
+		t.Fatalf("origin was not written")
+	}
+	if v, ok := group.cache.Get("Tom"); !ok || v.String() != "700" {
+		t.Fatalf("cache was not updated")
+	}
+
+	store.fail = true
+	if err := group.Set("Tom", []byte("800")); err == nil {
+		t.Fatalf("expected origin error")
+	}
+	if v, _ := group.cache.Get("Tom"); v.String() != "700" {
+		t.Fatalf("cache must not change when the origin write fails")
+	}
+
+	store.fail = false
+	if err := group.Delete("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := group.cache.Get("Tom"); ok || store.data["Tom"] != "" {
+		t.Fatalf("delete did not reach cache and origin")
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	store := &memStore{data: map[string]string{}, fail: true}
+	cfg := WriteBehindConfig{Path: path, FlushInterval: time.Hour}
+
+	q, err := NewWriteBehindQueue(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := NewGroup("write-behind", 2<<10, store, WithWriteBehind(q))
+	group.Set("Tom", []byte("1"))
+	group.Set("Tom", []byte("2"))
+	group.Set("Jack", []byte("3"))
+
+	if v, ok := group.cache.Get("Tom"); !ok || v.String() != "2" {
+		t.Fatalf("write-behind should update the cache at once")
+	}
+	q.Close()
+	// Tom=1 is superseded by Tom=2, the other two stay queued.
+	if q.Pending() != 2 {
+		t.Fatalf("failed writes should stay queued, pending = %d", q.Pending())
+	}
+
+	// Pending writes survive a restart and are applied in order.
+	store.fail = false
+	q, err = NewWriteBehindQueue(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending() != 2 {
+		t.Fatalf("journal replay lost writes, pending = %d", q.Pending())
+	}
+	q.Flush()
+	if q.Pending() != 0 || store.data["Tom"] != "2" || store.data["Jack"] != "3" {
+		t.Fatalf("flush did not apply writes: pending=%d data=%v", q.Pending(), store.data)
+	}
+	q.Close()
+
+	q, _ = NewWriteBehindQueue(store, cfg)
+	defer q.Close()
+	if q.Pending() != 0 {
+		t.Fatalf("flushed writes replayed after restart")
+	}
+}
+
+// batchStore counts the calls a write-behind queue makes to it.
+type batchStore struct {
+	memStore
+	batches, sets int
+}
+
+func (s *batchStore) Set(key string, value []byte) error {
+	s.sets++
+	return s.memStore.Set(key, value)
+}
+
+func (s *batchStore) SetBatch(values map[string][]byte) error {
+	s.batches++
+	for key, value := range values {
+		if err := s.memStore.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWriteBehindBatches(t *testing.T) {
+	store := &batchStore{memStore: memStore{data: map[string]string{}}}
+	q, err := NewWriteBehindQueue(store, WriteBehindConfig{FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	group := NewGroup("write-behind-batches", 2<<10, store, WithWriteBehind(q))
+	for i := 0; i < 10; i++ {
+		group.Set(fmt.Sprintf("key-%d", i), []byte("v"))
+	}
+	q.Flush()
+	if store.batches != 1 || store.sets != 0 || len(store.data) != 10 {
+		t.Fatalf("expected one batch of 10 writes, got %d batches, %d sets, %d values", store.batches, store.sets, len(store.data))
+	}
+
+	// Closing twice, as a deferred Close after an explicit one does, is safe.
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,363 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	opSet    = "set"
+	opDelete = "delete"
+	opAck    = "ack"
+)
+
+type WriteBehindConfig struct {
+	// Path of the journal file. Pending writes survive a restart when set;
+	// an empty Path keeps the queue in memory only.
+	Path string
+	// FlushInterval is how often pending writes are flushed (default 1s).
+	FlushInterval time.Duration
+	// BatchSize triggers an early flush once this many writes are pending
+	// (default 100).
+	BatchSize int
+	// MaxBackoff caps the retry delay for a key whose write keeps failing
+	// (default 1m).
+	MaxBackoff time.Duration
+}
+
+// writeOp is one journal record.
+type writeOp struct {
+	Seq   uint64 `json:"seq"`
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// WriteBehindQueue journals writes and flushes them to the origin in
+// batches. Writes to the same key are applied in order: a failed write
+// blocks later writes to that key until it succeeds, and a batch holding
+// several writes to one key only sends the last of them. A batch's sets go to
+// the origin in one SetBatch call if the setter implements BatchSetter, and
+// one Set per key otherwise.
+type WriteBehindQueue struct {
+	flushMu sync.Mutex // serializes flushes
+	mu      sync.Mutex // protects the fields below
+	setter  Setter
+	batcher BatchSetter // nil if setter cannot take batches
+	deleter Deleter
+	cfg     WriteBehindConfig
+	file    *os.File // nil for an in-memory queue
+	pending []*writeOp
+	seq     uint64
+	retries map[string]int       // consecutive failures per key
+	retryAt map[string]time.Time // when a failing key may be tried again
+
+	kick      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewWriteBehindQueue opens the journal at cfg.Path, reloads any writes that
+// were not flushed before the last shutdown and starts the flusher.
+func NewWriteBehindQueue(setter Setter, cfg WriteBehindConfig) (*WriteBehindQueue, error) {
+	if setter == nil {
+		return nil, fmt.Errorf("setter cannot be nil")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	q := &WriteBehindQueue{
+		setter:  setter,
+		cfg:     cfg,
+		retries: make(map[string]int),
+		retryAt: make(map[string]time.Time),
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	q.deleter, _ = setter.(Deleter)
+	q.batcher, _ = setter.(BatchSetter)
+
+	if cfg.Path != "" {
+		f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening write-behind journal: %w", err)
+		}
+		if err := q.replay(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		q.file = f
+	}
+
+	go q.run()
+	return q, nil
+}
+
+// Pending returns the number of writes not yet flushed to the origin.
+func (q *WriteBehindQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Flush sends every pending write that is not waiting for a retry.
+func (q *WriteBehindQueue) Flush() {
+	q.flush(false)
+}
+
+// Close stops the flusher after one last flush attempt. Writes still pending
+// stay in the journal for the next start. Calls after the first return the
+// first one's result.
+func (q *WriteBehindQueue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.stop)
+		<-q.done
+		q.flush(true)
+		if q.file != nil {
+			q.closeErr = q.file.Close()
+		}
+	})
+	return q.closeErr
+}
+
+func (q *WriteBehindQueue) enqueue(op, key string, value []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	w := &writeOp{Seq: q.seq, Op: op, Key: key, Value: value}
+	if err := q.journal(w); err != nil {
+		return err
+	}
+	q.pending = append(q.pending, w)
+	if len(q.pending) >= q.cfg.BatchSize {
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *WriteBehindQueue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.kick:
+		case <-q.stop:
+			return
+		}
+		q.flush(false)
+	}
+}
+
+// flush applies a snapshot of the pending writes. With force set, retry
+// backoff is ignored.
+func (q *WriteBehindQueue) flush(force bool) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+	q.mu.Lock()
+	batch := append([]*writeOp(nil), q.pending...)
+	q.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	last := make(map[string]uint64, len(batch))
+	for _, w := range batch {
+		last[w.Key] = w.Seq
+	}
+
+	now := time.Now()
+	blocked := make(map[string]bool)
+	flushed := make(map[uint64]bool, len(batch))
+	var sets []*writeOp // sent together through q.batcher
+	for _, w := range batch {
+		if blocked[w.Key] {
+			continue
+		}
+		if !force && now.Before(q.retryAt[w.Key]) {
+			blocked[w.Key] = true
+			continue
+		}
+		if w.Seq != last[w.Key] {
+			// A later write to the same key supersedes this one.
+			flushed[w.Seq] = true
+			continue
+		}
+		if w.Op == opSet && q.batcher != nil {
+			sets = append(sets, w)
+			continue
+		}
+		q.applied(w, q.apply(w), flushed)
+	}
+	if len(sets) > 0 {
+		values := make(map[string][]byte, len(sets))
+		for _, w := range sets {
+			values[w.Key] = w.Value
+		}
+		err := q.batcher.SetBatch(values)
+		for _, w := range sets {
+			q.applied(w, err, flushed)
+		}
+	}
+	q.complete(flushed)
+}
+
+// applied records the outcome of sending w, the last write to its key in a
+// batch.
+func (q *WriteBehindQueue) applied(w *writeOp, err error, flushed map[uint64]bool) {
+	if err != nil {
+		log.Printf("[write-behind] %s %q failed: %v", w.Op, w.Key, err)
+		q.backoff(w.Key)
+		return
+	}
+	flushed[w.Seq] = true
+	q.mu.Lock()
+	delete(q.retries, w.Key)
+	delete(q.retryAt, w.Key)
+	q.mu.Unlock()
+}
+
+func (q *WriteBehindQueue) apply(w *writeOp) error {
+	if w.Op == opDelete {
+		if q.deleter == nil {
+			return fmt.Errorf("setter does not implement Deleter")
+		}
+		return q.deleter.Delete(w.Key)
+	}
+	return q.setter.Set(w.Key, w.Value)
+}
+
+func (q *WriteBehindQueue) backoff(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retries[key]++
+	delay := q.cfg.FlushInterval << (q.retries[key] - 1)
+	if delay > q.cfg.MaxBackoff || delay <= 0 {
+		delay = q.cfg.MaxBackoff
+	}
+	q.retryAt[key] = time.Now().Add(delay)
+}
+
+// complete drops flushed writes from the queue and acknowledges them in the
+// journal. The journal is truncated once nothing is pending.
+func (q *WriteBehindQueue) complete(flushed map[uint64]bool) {
+	if len(flushed) == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	remaining := q.pending[:0]
+	for _, w := range q.pending {
+		if !flushed[w.Seq] {
+			remaining = append(remaining, w)
+		}
+	}
+	q.pending = remaining
+	if q.file == nil {
+		return
+	}
+
+	if len(q.pending) == 0 {
+		if err := q.file.Truncate(0); err == nil {
+			_, err = q.file.Seek(0, io.SeekStart)
+			if err == nil {
+				return
+			}
+		}
+	}
+	for seq := range flushed {
+		if err := q.journal(&writeOp{Seq: seq, Op: opAck}); err != nil {
+			log.Printf("[write-behind] journaling ack: %v", err)
+			return
+		}
+	}
+}
+
+// journal appends w to the journal file and syncs it. q.mu must be held.
+func (q *WriteBehindQueue) journal(w *writeOp) error {
+	if q.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing write-behind journal: %w", err)
+	}
+	return q.file.Sync()
+}
+
+// replay loads unacknowledged writes from the journal.
+func (q *WriteBehindQueue) replay(f *os.File) error {
+	var (
+		ops    []*writeOp
+		acked  = make(map[uint64]bool)
+		offset int64 // end of the last complete record
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var w writeOp
+		if err := json.Unmarshal(scanner.Bytes(), &w); err != nil {
+			// A torn final line from a crash mid-write is expected.
+			break
+		}
+		offset += int64(len(scanner.Bytes())) + 1
+		if w.Seq > q.seq {
+			q.seq = w.Seq
+		}
+		if w.Op == opAck {
+			acked[w.Seq] = true
+			continue
+		}
+		ops = append(ops, &w)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading write-behind journal: %w", err)
+	}
+	for _, w := range ops {
+		if !acked[w.Seq] {
+			q.pending = append(q.pending, w)
+		}
+	}
+	// Drop any torn tail so new records start on a clean line.
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	missingNewline := offset > info.Size()
+	if missingNewline {
+		offset = info.Size()
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if missingNewline {
+		_, err = f.Write([]byte{'\n'})
+	}
+	return err
+}
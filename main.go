@@ -4,8 +4,12 @@ import (
 	cache "distributed-cache/cache"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 )
 
 var db = map[string]string{
@@ -25,44 +29,148 @@ func createGroup() *cache.Group {
 		}))
 }
 
-func startCacheServer(addr string, addrs []string, gee *cache.Group) {
-	peers := cache.NewHTTPPool(addr)
+// hostPort strips the scheme from a peer address.
+func hostPort(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[i+3:]
+	}
+	return addr
+}
+
+// loadSigner reads signing keys from path, one "<id> <secret>" per line;
+// the first line's key signs.
+func loadSigner(path string) (*cache.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []cache.SigningKey
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: want \"<id> <secret>\" per line", path)
+		}
+		keys = append(keys, cache.SigningKey{ID: fields[0], Secret: []byte(fields[1])})
+	}
+	return cache.NewSigner(0, keys...)
+}
+
+func startCacheServer(addr string, addrs []string, gee *cache.Group, peerTLS *cache.PeerTLS, signer *cache.Signer, compress bool) {
+	var opts []cache.HTTPPoolOption
+	if compress {
+		opts = append(opts, cache.WithCompression(0))
+	}
+	if peerTLS != nil {
+		opts = append(opts, cache.WithTLS(peerTLS))
+	}
+	if signer != nil {
+		opts = append(opts, cache.WithSigner(signer))
+	}
+	peers := cache.NewHTTPPool(addr, opts...)
 	peers.Set(addrs...)
 	gee.RegisterPeers(peers)
 	log.Println("cache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	srv := &http.Server{Addr: hostPort(addr), Handler: peers}
+	if peerTLS != nil {
+		srv.TLSConfig = peerTLS.ServerConfig()
+		log.Fatal(srv.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(srv.ListenAndServe())
+}
+
+func startGRPCCacheServer(addr string, addrs []string, gee *cache.Group, peerTLS *cache.PeerTLS, signer *cache.Signer, compress bool) {
+	targets := make([]string, len(addrs))
+	for i, a := range addrs {
+		targets[i] = hostPort(a)
+	}
+	self := hostPort(addr)
+	var opts []cache.GRPCPoolOption
+	if compress {
+		opts = append(opts, cache.WithGRPCCompression())
+	}
+	if peerTLS != nil {
+		opts = append(opts, cache.WithGRPCTLS(peerTLS))
+	}
+	if signer != nil {
+		opts = append(opts, cache.WithGRPCSigner(signer))
+	}
+	peers := cache.NewGRPCPool(self, opts...)
+	peers.Set(targets...)
+	gee.RegisterPeers(peers)
+	lis, err := net.Listen("tcp", self)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("grpc cache is running at", self)
+	log.Fatal(peers.NewServer().Serve(lis))
 }
 
 func startAPIServer(apiAddr string, gee *cache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := gee.Get(key)
+			value, err := gee.GetReader(key)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.Bytes())
+			io.Copy(w, value)
 
 		}))
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(hostPort(apiAddr), nil))
 
 }
 
 func main() {
 	var port int
 	var api bool
+	var transport string
+	var tlsConfig cache.TLSConfig
+	var signKeys string
+	var compress bool
 	flag.IntVar(&port, "port", 8001, "cache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&transport, "transport", "http", "peer transport: http or grpc")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "peer TLS certificate, PEM; enables TLS between peers")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "peer TLS private key, PEM")
+	flag.StringVar(&tlsConfig.CAFile, "tls-ca", "", "CA bundle peer certificates must chain to; empty uses the system roots")
+	flag.BoolVar(&tlsConfig.RequireClientCert, "mtls", false, "require peers to present a client certificate")
+	flag.StringVar(&signKeys, "sign-keys", "", "file of \"<id> <secret>\" lines; signs peer requests and rejects unsigned ones")
+	flag.BoolVar(&compress, "compress", false, "compress peer traffic")
 	flag.Parse()
+	if transport != "http" && transport != "grpc" {
+		fmt.Fprintf(os.Stderr, "unknown -transport %q: want http or grpc\n", transport)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	scheme := "http"
+	var peerTLS *cache.PeerTLS
+	if tlsConfig.CertFile != "" {
+		var err error
+		if peerTLS, err = cache.NewPeerTLS(tlsConfig); err != nil {
+			log.Fatal(err)
+		}
+		scheme = "https"
+	}
+	var signer *cache.Signer
+	if signKeys != "" {
+		var err error
+		if signer, err = loadSigner(signKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	apiAddr := "http://localhost:8080"
 	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+		8001: scheme + "://localhost:8001",
+		8002: scheme + "://localhost:8002",
+		8003: scheme + "://localhost:8003",
 	}
 
 	var addrs []string
@@ -74,5 +182,9 @@ func main() {
 	if api {
 		go startAPIServer(apiAddr, gee)
 	}
-	startCacheServer(addrMap[port], []string(addrs), gee)
+	if transport == "grpc" {
+		startGRPCCacheServer(addrMap[port], addrs, gee, peerTLS, signer, compress)
+		return
+	}
+	startCacheServer(addrMap[port], []string(addrs), gee, peerTLS, signer, compress)
 }